@@ -8,30 +8,108 @@ import (
 )
 
 const (
+	queueKeyPrefix     = "queueKey"
 	queueItemKeyPrefix = "queueItemKey"
+	queueNameParam     = "queueName"
 	itemIDParam        = "itemID"
 	afterItemIDParam   = "afterItemID"
 	beforeItemIDParam  = "beforeItemID"
 )
 
 // New inits a chaincode, adds chaincode methods to the rourer
-// All methods allow access to anyone
+// Queue methods allow access to anyone unless the method ACL is set,
+// queue management methods allowed only to the chaincode owner
 func New() *router.Chaincode {
 	r := router.New("hlfq") // also initialized logger with "hlfq_*" prefix
 
+	// check ACL stored on the ledger before any method
+	r.Use(aclCheck)
+	// emit one event with all item changes made by the tx
+	r.After(emitQueueEvent)
+	// save queue counters changed by the tx
+	r.After(saveListStats)
+	// keep writes of the tx in memory, so the tx reads them back (must be the last, it wraps all others)
+	r.After(txState)
+
 	// Method for debug chaincode state
 	debug.AddHandlers(r, "debug", owner.Only)
 
 	r.Init(invokeInitLedger) // no params
 
+	// queue management
+	r.
+		Invoke("CreateQueue", queueCreate, owner.Only, pdef.String(queueNameParam)).
+		Invoke("DeleteQueue", queueDelete, owner.Only, pdef.String(queueNameParam), queueMustExist).
+		Invoke("SetMaxDeliveryAttempts", queueSetMaxDeliveryAttempts, owner.Only,
+			pdef.String(queueNameParam), queueMustExist, pdef.Int(maxDeliveryAttemptsParam)).
+		Query("ListQueues", queueListQueues).
+		Invoke("PurgeDeadLetters", queuePurgeDeadLetters, owner.Only, pdef.String(queueNameParam), queueMustExist).
+		Invoke("SetArchiveMode", queueSetArchiveMode, owner.Only, pdef.String(queueNameParam), queueMustExist,
+			pdef.Bool(archiveModeParam)).
+		Invoke("SetPrivateCollection", queueSetPrivateCollection, owner.Only, pdef.String(queueNameParam),
+			queueMustExist, pdef.String(privateCollectionParam), pdef.Strings(privateReadersParam)).
+		Invoke("SetValidationRules", queueSetValidationRules, owner.Only, pdef.String(queueNameParam), queueMustExist,
+			pdef.Struct(validationRulesParam, &[]FieldRule{})).
+		Query("GetValidationRules", queueGetValidationRules, pdef.String(queueNameParam), queueMustExist).
+		Query("Verify", queueVerify, pdef.String(queueNameParam), queueMustExist).
+		Invoke("Repair", queueRepair, owner.Only, pdef.String(queueNameParam), queueMustExist).
+		Invoke("SetACL", aclSet, owner.Only, pdef.Struct(aclParam, &ACL{})).
+		Invoke("DeleteACL", aclDelete, owner.Only, pdef.String(methodParam)).
+		Query("ListACL", aclList).
+		Invoke("SetQueueAdmins", queueAdminsSet, owner.Only, pdef.Struct(queueAdminsParam, &QueueAdmins{})).
+		Query("GetQueueAdmins", queueAdminsGet)
+
+	// every queue method accepts a queue name as the first argument,
+	// methods changing the lists link pending items first,
+	// methods changing an item by ID are allowed to its creator or a queue admin,
+	// queries returning items decrypt ExtraData if the caller passes the key
 	r.
-		Invoke("Push", queuePush, pdef.Struct(newItemSpecParam, &QueueItemSpec{})).
-		Invoke("Pop", queuePop).
-		Invoke("ListItems", queueListItems).
-		Invoke("AttachData", queueAttachData, pdef.String(itemIDParam), pdef.Bytes(attachedDataParam)).
-		Invoke("MoveAfter", queueMoveAfter, pdef.String(itemIDParam), pdef.String(afterItemIDParam)).
-		Invoke("MoveBefore", queueMoveBefore, pdef.String(itemIDParam), pdef.String(beforeItemIDParam)).
-		Query("Select", queueSelect, pdef.String(selectQueryStringParam))
+		Invoke("Push", queuePush, pdef.String(queueNameParam), queueMustExist,
+			pdef.Struct(newItemSpecParam, &QueueItemSpec{})).
+		Invoke("PushBatch", queuePushBatch, pdef.String(queueNameParam), queueMustExist,
+			pdef.Struct(newItemSpecsParam, &[]QueueItemSpec{})).
+		Invoke("Compact", queueCompact, pdef.String(queueNameParam), queueMustExist).
+		Invoke("PushFront", queuePushFront, pdef.String(queueNameParam), queueMustExist,
+			pdef.Struct(newItemSpecParam, &QueueItemSpec{})).
+		Invoke("Pop", queuePop, pdef.String(queueNameParam), queueMustExist, linkPending).
+		Invoke("PopN", queuePopN, pdef.String(queueNameParam), queueMustExist, linkPending, pdef.Int(popCountParam)).
+		Invoke("PopBack", queuePopBack, pdef.String(queueNameParam), queueMustExist, linkPending).
+		Query("Peek", queuePeek, pdef.String(queueNameParam), queueMustExist, decryptResult).
+		Query("PeekTail", queuePeekTail, pdef.String(queueNameParam), queueMustExist, decryptResult).
+		Query("PeekN", queuePeekN, pdef.String(queueNameParam), queueMustExist, pdef.Int(peekCountParam),
+			decryptResult).
+		Invoke("Remove", queueRemove, pdef.String(queueNameParam), queueMustExist, linkPending,
+			pdef.String(itemIDParam), itemOwnerOnly).
+		Invoke("Reserve", queueReserve, pdef.String(queueNameParam), queueMustExist, linkPending, pdef.Int(leaseTimeoutParam)).
+		Invoke("Ack", queueAck, pdef.String(queueNameParam), queueMustExist, linkPending, pdef.String(itemIDParam)).
+		Invoke("Nack", queueNack, pdef.String(queueNameParam), queueMustExist, linkPending, pdef.String(itemIDParam)).
+		Query("ListDeadLetters", queueListDeadLetters, pdef.String(queueNameParam), queueMustExist, decryptResult).
+		Query("GetDeadLetter", queueGetDeadLetter, pdef.String(queueNameParam), queueMustExist,
+			pdef.String(itemIDParam), decryptResult).
+		Invoke("RequeueDeadLetter", queueRequeueDeadLetter, pdef.String(queueNameParam), queueMustExist, linkPending,
+			pdef.String(itemIDParam), pdef.String(requeuePositionParam)).
+		Query("ListItems", queueListItems, pdef.String(queueNameParam), queueMustExist, decryptResult).
+		Query("ListItemsPage", queueListItemsPage, pdef.String(queueNameParam), queueMustExist,
+			pdef.String(startAfterIDParam), pdef.Int(pageLimitParam), decryptResult).
+		Query("Stats", queueStats, pdef.String(queueNameParam), queueMustExist).
+		Query("ListScheduled", queueListScheduled, pdef.String(queueNameParam), queueMustExist, decryptResult).
+		Invoke("AttachData", queueAttachData, pdef.String(queueNameParam), queueMustExist, linkPending,
+			pdef.String(itemIDParam), itemOwnerOnly, pdef.Bytes(attachedDataParam)).
+		Invoke("MoveAfter", queueMoveAfter, pdef.String(queueNameParam), queueMustExist, linkPending,
+			pdef.String(itemIDParam), itemOwnerOnly, pdef.String(afterItemIDParam)).
+		Invoke("MoveBefore", queueMoveBefore, pdef.String(queueNameParam), queueMustExist, linkPending,
+			pdef.String(itemIDParam), itemOwnerOnly, pdef.String(beforeItemIDParam)).
+		Query("ListArchive", queueListArchive, pdef.String(queueNameParam), queueMustExist,
+			pdef.String(archiveFromParam), pdef.String(archiveToParam), decryptResult).
+		Query("GetArchived", queueGetArchived, pdef.String(queueNameParam), queueMustExist, pdef.String(itemIDParam),
+			decryptResult).
+		Invoke("Requeue", queueRequeue, pdef.String(queueNameParam), queueMustExist, linkPending,
+			pdef.String(itemIDParam)).
+		Query("GetPrivateExtraData", queueGetPrivateExtraData, pdef.String(queueNameParam), queueMustExist,
+			pdef.String(itemIDParam)).
+		Query("ItemHistory", queueItemHistory, pdef.String(queueNameParam), queueMustExist, pdef.String(itemIDParam)).
+		Query("Select", queueSelect, pdef.String(queueNameParam), queueMustExist,
+			pdef.String(selectQueryStringParam), decryptResult)
 
 	return router.NewChaincode(r)
 }
@@ -0,0 +1,212 @@
+package hlfq
+
+import (
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/extensions/owner"
+	"github.com/s7techlab/cckit/router"
+)
+
+const (
+	aclKeyPrefix         = "aclKey"
+	aclParam             = "acl"
+	methodParam          = "method"
+	queueAdminsKeyPrefix = "queueAdminsKey"
+	queueAdminsParam     = "admins"
+)
+
+// ErrAccessDenied occurs when the tx creator is not allowed to call the method by its ACL
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrPermissionDenied occurs when the tx creator changes an item of another creator and is not a queue admin
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Queue admins may change items of any creator, the chaincode owner is always a queue admin.
+// Other admins are identities matching the principals set by the owner (QueueAdmins), a principal
+// names the MSP, usually with the certificate attribute AdminRoleAttribute set to AdminRoleValue.
+const (
+	AdminRoleAttribute = "hlfq.role"
+	AdminRoleValue     = "admin"
+)
+
+// ACLPrincipal describes identities allowed to call a method.
+// Empty MSPID matches any MSP, empty Attribute means certificate attributes are not checked.
+type ACLPrincipal struct {
+	MSPID     string `json:"MSPID"`
+	Attribute string `json:"Attribute"`
+	Value     string `json:"Value"`
+}
+
+// ACL lists principals allowed to call the chaincode method, the chaincode owner is always allowed
+type ACL struct {
+	Method string         `json:"Method"`
+	Allow  []ACLPrincipal `json:"Allow"`
+}
+
+// Key for ACL entry in chaincode state
+func (a ACL) Key() ([]string, error) {
+	return []string{aclKeyPrefix, a.Method}, nil
+}
+
+// QueueAdmins lists principals of queue admins besides the chaincode owner.
+// Every principal must have MSPID, so an identity of an MSP not listed by the owner is never an admin.
+type QueueAdmins struct {
+	Allow []ACLPrincipal `json:"Allow"`
+}
+
+// Key for QueueAdmins entry in chaincode state
+func (a QueueAdmins) Key() ([]string, error) {
+	return []string{queueAdminsKeyPrefix}, nil
+}
+
+// aclSet stores ACL for the method, replaces existing one
+// arg1 -> acl ACL
+func aclSet(c router.Context) (interface{}, error) {
+	acl := c.Param(aclParam).(ACL)
+	if acl.Method == "" {
+		return nil, errors.New("Empty ACL method")
+	}
+	if err := putState(c, acl); err != nil {
+		return nil, errors.Wrap(err, "failed to save ACL")
+	}
+	return acl, nil
+}
+
+// aclDelete deletes ACL of the method, so the method is allowed to anyone
+// arg1 -> method string
+func aclDelete(c router.Context) (interface{}, error) {
+	acl := ACL{Method: c.ParamString(methodParam)}
+	return acl, deleteState(c, acl)
+}
+
+// aclList returns all stored ACLs
+func aclList(c router.Context) (interface{}, error) {
+	res, err := c.State().List(aclKeyPrefix, &ACL{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list ACL")
+	}
+	acls := []ACL{}
+	for _, acl := range res.([]interface{}) {
+		acls = append(acls, acl.(ACL))
+	}
+	return acls, nil
+}
+
+// queueAdminsSet stores queue admin principals, replaces existing ones
+// arg1 -> admins QueueAdmins
+func queueAdminsSet(c router.Context) (interface{}, error) {
+	admins := c.Param(queueAdminsParam).(QueueAdmins)
+	for i, p := range admins.Allow {
+		if p.MSPID == "" {
+			return nil, errors.Errorf("Empty MSPID of queue admin principal #%d", i)
+		}
+	}
+	if err := putState(c, admins); err != nil {
+		return nil, errors.Wrap(err, "failed to save queue admins")
+	}
+	return admins, nil
+}
+
+// queueAdminsGet returns queue admin principals
+func queueAdminsGet(c router.Context) (interface{}, error) {
+	return readQueueAdmins(c)
+}
+
+func readQueueAdmins(c router.Context) (QueueAdmins, error) {
+	res, err := c.State().Get(QueueAdmins{}, &QueueAdmins{}, QueueAdmins{Allow: []ACLPrincipal{}})
+	if err != nil {
+		return QueueAdmins{}, errors.Wrap(err, "failed to read queue admins")
+	}
+	return res.(QueueAdmins), nil
+}
+
+// aclCheck is a router middleware enforces ACL of the called method.
+// Methods without ACL are allowed to anyone.
+func aclCheck(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		res, err := c.State().Get(ACL{Method: c.Path()}, &ACL{}, nil) // nil if no ACL
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read ACL")
+		}
+		if res == nil {
+			return next(c)
+		}
+		allowed, err := isAllowed(c, res.(ACL))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to check ACL")
+		}
+		if !allowed {
+			return nil, errors.Errorf("%s: %s", ErrAccessDenied, c.Path())
+		}
+		return next(c)
+	}
+}
+
+// isAllowed checks the tx creator is the chaincode owner or matches any of ACL principals
+func isAllowed(c router.Context, acl ACL) (bool, error) {
+	if isOwner, err := owner.IsInvoker(c); err != nil || isOwner {
+		return isOwner, err
+	}
+	client, err := c.Client()
+	if err != nil {
+		return false, err
+	}
+	mspID, err := client.GetMSPID()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range acl.Allow {
+		if p.MSPID != "" && p.MSPID != mspID {
+			continue
+		}
+		if p.Attribute != "" {
+			value, found, err := client.GetAttributeValue(p.Attribute)
+			if err != nil {
+				return false, err
+			}
+			if !found || value != p.Value {
+				continue
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// itemOwnerOnly is a router middleware allows to change the item only to its creator or a queue admin.
+// Items stored without the creator can be changed only by queue admins.
+func itemOwnerOnly(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		itemIDStr := c.ParamString(itemIDParam)
+		item, err := readQueueItemByID(c, c.ParamString(queueNameParam), itemIDStr)
+		if err != nil {
+			// a missing item is reported by the method
+			return next(c)
+		}
+		allowed, err := isItemOwnerOrAdmin(c, item)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to check item owner")
+		}
+		if !allowed {
+			return nil, errors.Errorf("%s: item '%s' can be changed only by its creator or a queue admin",
+				ErrPermissionDenied, itemIDStr)
+		}
+		return next(c)
+	}
+}
+
+// isItemOwnerOrAdmin checks the tx creator pushed the item or is a queue admin
+func isItemOwnerOrAdmin(c router.Context, item QueueItem) (bool, error) {
+	invoker, err := invokerActor(c)
+	if err != nil {
+		return false, err
+	}
+	if !item.Creator.isEmpty() && invoker == item.Creator {
+		return true, nil
+	}
+	admins, err := readQueueAdmins(c)
+	if err != nil {
+		return false, err
+	}
+	// the owner is allowed by isAllowed too
+	return isAllowed(c, ACL{Allow: admins.Allow})
+}
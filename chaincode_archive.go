@@ -0,0 +1,218 @@
+package hlfq
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const (
+	archivedItemKeyPrefix = "archivedItemKey"
+	archiveTimeKeyPrefix  = "archiveTimeKey"
+	archiveModeParam      = "archive"
+	archiveFromParam      = "from"
+	archiveToParam        = "to"
+	// MaxArchiveRangeDays is the max time range of ListArchive
+	MaxArchiveRangeDays = 31
+	// archiveDayFormat and archiveTimeFormat keep the order of time index keys the same as the time order
+	archiveDayFormat  = "2006-01-02"
+	archiveTimeFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+// ArchivedItem is a consumed item kept in the archive of the queue with the consuming tx data
+type ArchivedItem struct {
+	Item       QueueItem `json:"Item"`
+	PoppedTime time.Time `json:"PoppedTime"`
+	PoppedBy   Actor     `json:"PoppedBy"`
+	TxID       string    `json:"TxID"`
+	Method     string    `json:"Method"`
+}
+
+// Key for ArchivedItem entry in chaincode state
+func (a ArchivedItem) Key() ([]string, error) {
+	return []string{archivedItemKeyPrefix, a.Item.QueueName, a.Item.ID.String()}, nil
+}
+
+// archiveTimeIndex is a time index entry of an archived item.
+// Fabric can't query a range of composite keys, so entries are grouped by the pop day
+// and a time range is read by the day partial keys.
+type archiveTimeIndex struct {
+	QueueName  string    `json:"QueueName"`
+	PoppedTime time.Time `json:"PoppedTime"`
+	ID         string    `json:"ID"`
+}
+
+// Key for archiveTimeIndex entry in chaincode state
+func (i archiveTimeIndex) Key() ([]string, error) {
+	t := i.PoppedTime.UTC()
+	return []string{archiveTimeKeyPrefix, i.QueueName, t.Format(archiveDayFormat), t.Format(archiveTimeFormat), i.ID}, nil
+}
+
+func (a ArchivedItem) timeIndex() archiveTimeIndex {
+	return archiveTimeIndex{QueueName: a.Item.QueueName, PoppedTime: a.PoppedTime, ID: a.Item.ID.String()}
+}
+
+// queueSetArchiveMode turns archiving of consumed items of the queue on or off
+// arg1 -> queueName string
+// arg2 -> archive bool
+func queueSetArchiveMode(c router.Context) (interface{}, error) {
+	queue, err := readQueue(c, c.ParamString(queueNameParam))
+	if err != nil {
+		return nil, err
+	}
+	queue.ArchivePopped = c.Param(archiveModeParam).(bool)
+	if err := putState(c, queue); err != nil {
+		return nil, errors.Wrap(err, "failed to update queue")
+	}
+	return queue, nil
+}
+
+// consumeItem deletes the unlinked item consumed by Pop, PopBack or Ack,
+// the item moves to the archive if the queue archive mode is on
+func consumeItem(c router.Context, item QueueItem) error {
+	if err := deleteState(c, item); err != nil {
+		return err
+	}
+	queue, err := readQueue(c, item.QueueName)
+	if err != nil {
+		return err
+	}
+	if !queue.ArchivePopped {
+		return deletePrivateExtraData(c, item)
+	}
+	t, err := c.Time()
+	if err != nil {
+		return errors.Wrap(err, "failed to get tx time")
+	}
+	archived := ArchivedItem{Item: item, PoppedTime: t.UTC(), TxID: c.Stub().GetTxID(), Method: c.Path()}
+	archived.Item.PrevKey, archived.Item.NextKey = EmptyItemPointerKey, EmptyItemPointerKey
+	if archived.PoppedBy, err = invokerActor(c); err != nil {
+		return err
+	}
+	if err := insertState(c, archived); err != nil {
+		return errors.Wrap(err, "failed to archive item")
+	}
+	if err := insertState(c, archived.timeIndex()); err != nil {
+		return errors.Wrap(err, "failed to index archived item")
+	}
+	return nil
+}
+
+// queueGetArchived returns the archived item by ID
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueGetArchived(c router.Context) (interface{}, error) {
+	return readArchivedItem(c, c.ParamString(queueNameParam), c.ParamString(itemIDParam))
+}
+
+// queueListArchive returns items archived from the time from (inclusive) to the time to (exclusive)
+// sorted by the pop time, the range is limited to MaxArchiveRangeDays
+// arg1 -> queueName string
+// arg2 -> from string (RFC3339 time)
+// arg3 -> to string (RFC3339 time)
+func queueListArchive(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	from, err := time.Parse(time.RFC3339Nano, c.ParamString(archiveFromParam))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid from time")
+	}
+	to, err := time.Parse(time.RFC3339Nano, c.ParamString(archiveToParam))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid to time")
+	}
+	if to.Before(from) || to.Sub(from) > MaxArchiveRangeDays*24*time.Hour {
+		return nil, errors.Errorf("Archive time range must be from 0 to %d days", MaxArchiveRangeDays)
+	}
+
+	var index []archiveTimeIndex
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		res, err := c.State().List([]string{archiveTimeKeyPrefix, queueName, day.Format(archiveDayFormat)},
+			&archiveTimeIndex{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list archive index")
+		}
+		for _, entry := range res.([]interface{}) {
+			i := entry.(archiveTimeIndex)
+			if !i.PoppedTime.Before(from) && i.PoppedTime.Before(to) {
+				index = append(index, i)
+			}
+		}
+	}
+	sort.Slice(index, func(i, j int) bool {
+		if !index[i].PoppedTime.Equal(index[j].PoppedTime) {
+			return index[i].PoppedTime.Before(index[j].PoppedTime)
+		}
+		return index[i].ID < index[j].ID
+	})
+
+	items := []ArchivedItem{}
+	for _, i := range index {
+		archived, err := readArchivedItem(c, queueName, i.ID)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, archived)
+	}
+	return items, nil
+}
+
+// queueRequeue moves the archived item back to the tail of its priority band,
+// the lease and delivery attempts are reset, the item keeps its ID
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueRequeue(c router.Context) (interface{}, error) {
+	archived, err := readArchivedItem(c, c.ParamString(queueNameParam), c.ParamString(itemIDParam))
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteState(c, archived); err != nil {
+		return nil, errors.Wrap(err, "failed to delete archived item")
+	}
+	if err := deleteState(c, archived.timeIndex()); err != nil {
+		return nil, errors.Wrap(err, "failed to delete archive index")
+	}
+
+	item := archived.Item
+	item.LeaseOwner, item.LeaseExpires = Actor{}, time.Time{}
+	item.DeliveryAttempts, item.DeadLettered = 0, false
+	if err := linkToTail(c, &item); err != nil {
+		return nil, errors.Wrap(err, "failed to link requeued item")
+	}
+	if err := insertState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to save requeued item")
+	}
+	addItemChange(c, ItemRequeued, nil, &item)
+	return item, nil
+}
+
+func readArchivedItem(c router.Context, queueName string, itemIDStr string) (archived ArchivedItem, err error) {
+	key := []string{archivedItemKeyPrefix, queueName, itemIDStr}
+	res, err := c.State().Get(key, &ArchivedItem{})
+	if err != nil {
+		return archived, errors.Wrapf(newStateError(StateGet, key, err), "failed to read archived item ID '%s'", itemIDStr)
+	}
+	return res.(ArchivedItem), nil
+}
+
+// deleteArchive deletes all archived items of the queue with the time index
+func deleteArchive(c router.Context, queueName string) error {
+	res, err := c.State().List([]string{archivedItemKeyPrefix, queueName}, &ArchivedItem{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list archived items")
+	}
+	for _, entry := range res.([]interface{}) {
+		archived := entry.(ArchivedItem)
+		if err := deleteState(c, archived); err != nil {
+			return errors.Wrap(err, "failed to delete archived item")
+		}
+		if err := deletePrivateExtraData(c, archived.Item); err != nil {
+			return err
+		}
+		if err := deleteState(c, archived.timeIndex()); err != nil {
+			return errors.Wrap(err, "failed to delete archive index")
+		}
+	}
+	return nil
+}
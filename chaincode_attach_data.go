@@ -7,21 +7,43 @@ import (
 
 const attachedDataParam = "attachedData"
 
-// attcahes extra data to the item specified by key, returns error if key not exists
-// arg1 -> attachDataMethodParamKey string
-// arg2 -> attachDataMethodParamData []bytes
+// attcahes extra data to the item specified by key, returns error if key not exists.
+// The data of a queue with a private collection is passed in the transient map, attachDataMethodParamData is empty
+// arg1 -> queueName string
+// arg2 -> attachDataMethodParamKey string
+// arg3 -> attachDataMethodParamData []bytes
 func queueAttachData(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
 	itemIDStr := c.ParamString(itemIDParam)
-	extraData := c.ParamBytes(attachedDataParam)
-	item, err := readQueueItemByID(c, itemIDStr)
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	extraData, err := itemExtraData(c, queue, c.ParamBytes(attachedDataParam), TransientExtraDataKey)
+	if err != nil {
+		return nil, err
+	}
+	item, err := readQueueItemByID(c, queueName, itemIDStr)
 	if err != nil {
 		return nil, errors.Wrap(err, "can not read item to attach data")
 	}
-	item.ExtraData = []byte{} // reset
-	item.ExtraData = append(item.ExtraData, extraData...)
+	rules, err := readValidationRules(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	spec := item.spec()
+	spec.ExtraData = extraData
+	if err := rules.validate(spec); err != nil {
+		return nil, err
+	}
+	// replaces existing ExtraData
+	if err := setItemExtraData(c, queue, &item, append([]byte{}, extraData...)); err != nil {
+		return nil, err
+	}
 	// fmt.Printf("\n\n***** item=%+v\n\n", item)
-	if err := c.State().Put(item); err != nil {
+	if err := putState(c, item); err != nil {
 		return nil, errors.Wrap(err, "failed to update item with extra data")
 	}
+	addItemChange(c, ItemDataAttached, &item, &item)
 	return item, nil
 }
@@ -0,0 +1,223 @@
+package hlfq
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const (
+	requeuePositionParam = "requeuePosition"
+	// RequeueToHead puts a requeued item before the head of its priority band
+	RequeueToHead = "head"
+	// RequeueToTail puts a requeued item after the tail of its priority band
+	RequeueToTail = "tail"
+)
+
+// queueListDeadLetters returns dead-lettered items of the queue in the order they were dead-lettered
+// arg1 -> queueName string
+func queueListDeadLetters(c router.Context) (interface{}, error) {
+	items := []QueueItem{}
+	_, err := walkList(c, deadLetterList(c.ParamString(queueNameParam)), func(item QueueItem) (bool, error) {
+		items = append(items, item)
+		return false, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list dead-lettered items")
+	}
+	return items, nil
+}
+
+// queueGetDeadLetter returns the dead-lettered item by ID
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueGetDeadLetter(c router.Context) (interface{}, error) {
+	return readDeadLetter(c, c.ParamString(queueNameParam), c.ParamString(itemIDParam))
+}
+
+// queueRequeueDeadLetter moves the dead-lettered item back to the head or tail of its priority band,
+// delivery attempts counter is reset
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+// arg3 -> requeuePosition string (RequeueToHead or RequeueToTail)
+func queueRequeueDeadLetter(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	itemIDStr := c.ParamString(itemIDParam)
+	position := c.ParamString(requeuePositionParam)
+	if position != RequeueToHead && position != RequeueToTail {
+		return nil, errors.Errorf("Requeue position must be '%s' or '%s'", RequeueToHead, RequeueToTail)
+	}
+	if _, err := readDeadLetter(c, queueName, itemIDStr); err != nil {
+		return nil, err
+	}
+	item, err := cutItem(c, queueName, itemIDStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to cut dead-lettered item ID '%s'", itemIDStr)
+	}
+	old := item
+
+	item.DeadLettered = false
+	item.DeliveryAttempts = 0
+	link := linkToTail
+	if position == RequeueToHead {
+		link = linkToHead
+	}
+	if err := link(c, &item); err != nil {
+		return nil, errors.Wrap(err, "failed to link requeued item")
+	}
+	if err := putState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to save requeued item")
+	}
+	addItemChange(c, ItemRequeued, &old, &item)
+	return item, nil
+}
+
+// queuePurgeDeadLetters deletes all dead-lettered items of the queue, returns deleted items
+// arg1 -> queueName string
+func queuePurgeDeadLetters(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	res, err := queueListDeadLetters(c)
+	if err != nil {
+		return nil, err
+	}
+	items := res.([]QueueItem)
+	for _, item := range items {
+		if err := deleteState(c, item); err != nil {
+			return nil, errors.Wrap(err, "failed to delete dead-lettered item")
+		}
+		if err := deletePrivateExtraData(c, item); err != nil {
+			return nil, err
+		}
+		addItemChange(c, ItemDeleted, &item, nil)
+	}
+	l := deadLetterList(queueName)
+	if err := setHeadPointerTo(c, l, EmptyItemPointerKey); err != nil {
+		return nil, err
+	}
+	if err := setTailPointerTo(c, l, EmptyItemPointerKey); err != nil {
+		return nil, err
+	}
+	if err := deleteListStats(c, l); err != nil {
+		return nil, errors.Wrap(err, "failed to delete dead-letter list stats")
+	}
+	return items, nil
+}
+
+// moveToDeadLetters cuts the item from its priority band and links it to the tail of the dead-letter list,
+// the lease is released
+func moveToDeadLetters(c router.Context, item QueueItem) (QueueItem, error) {
+	item, err := cutItem(c, item.QueueName, item.ID.String())
+	if err != nil {
+		return item, errors.Wrapf(err, "failed to cut item ID '%s' to dead-letter", item.ID.String())
+	}
+	old := item
+	item.DeadLettered = true
+	item.LeaseOwner = Actor{}
+	item.LeaseExpires = time.Time{}
+	if err := linkToTail(c, &item); err != nil {
+		return item, errors.Wrap(err, "failed to link dead-lettered item")
+	}
+	if err := putState(c, item); err != nil {
+		return item, errors.Wrap(err, "failed to save dead-lettered item")
+	}
+	addItemChange(c, ItemDeadLettered, &old, &item)
+	return item, nil
+}
+
+// moveAllToDeadLetters moves the items found by one queue walk (in the walk order) to the tail
+// of the dead-letter list. The links of their neighbours and the pointers are worked out in memory,
+// each changed key is read and written once. Returns the changed neighbours by ID.
+func moveAllToDeadLetters(c router.Context, items []QueueItem) (map[string]QueueItem, error) {
+	neighbours := map[string]*QueueItem{}
+	neighbour := func(key []string) (*QueueItem, error) {
+		id := key[len(key)-1]
+		if item, ok := neighbours[id]; ok {
+			return item, nil
+		}
+		item, err := readQueueItem(c, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read neighbour of dead-lettered item")
+		}
+		neighbours[id] = &item
+		return &item, nil
+	}
+	for start := 0; start < len(items); {
+		// cut the run of items following one another in the band at once
+		end := start
+		for end+1 < len(items) && items[end+1].list() == items[start].list() {
+			nextKey, _ := items[end+1].Key()
+			if !reflect.DeepEqual(items[end].NextKey, nextKey) {
+				break
+			}
+			end++
+		}
+		first, last, l := items[start], items[end], items[start].list()
+		if isKeyEmpty(first.PrevKey) {
+			if err := setHeadPointerTo(c, l, last.NextKey); err != nil {
+				return nil, err
+			}
+		} else {
+			prev, err := neighbour(first.PrevKey)
+			if err != nil {
+				return nil, err
+			}
+			prev.NextKey = last.NextKey
+		}
+		if isKeyEmpty(last.NextKey) {
+			if err := setTailPointerTo(c, l, first.PrevKey); err != nil {
+				return nil, err
+			}
+		} else {
+			next, err := neighbour(last.NextKey)
+			if err != nil {
+				return nil, err
+			}
+			next.PrevKey = first.PrevKey
+		}
+		for _, item := range items[start : end+1] {
+			countItem(c, l, item, -1)
+		}
+		start = end + 1
+	}
+	changed := map[string]QueueItem{}
+	for id, item := range neighbours {
+		if err := putState(c, *item); err != nil {
+			return nil, errors.Wrap(err, "failed to save neighbour of dead-lettered item")
+		}
+		changed[id] = *item
+	}
+
+	moved := make([]*QueueItem, len(items))
+	for i := range items {
+		moved[i] = &QueueItem{}
+		*moved[i] = items[i]
+		moved[i].DeadLettered = true
+		moved[i].LeaseOwner = Actor{}
+		moved[i].LeaseExpires = time.Time{}
+	}
+	if len(items) > 0 {
+		if err := linkAllToTail(c, deadLetterList(items[0].QueueName), moved); err != nil {
+			return nil, errors.Wrap(err, "failed to link dead-lettered items")
+		}
+	}
+	for i, item := range moved {
+		if err := putState(c, *item); err != nil {
+			return nil, errors.Wrap(err, "failed to save dead-lettered item")
+		}
+		addItemChange(c, ItemDeadLettered, &items[i], item)
+	}
+	return changed, nil
+}
+
+func readDeadLetter(c router.Context, queueName string, itemIDStr string) (item QueueItem, err error) {
+	item, err = readQueueItemByID(c, queueName, itemIDStr)
+	if err != nil {
+		return item, err
+	}
+	if !item.DeadLettered {
+		return item, errors.Errorf("Item is not dead-lettered: %s", itemIDStr)
+	}
+	return item, nil
+}
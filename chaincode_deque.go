@@ -0,0 +1,97 @@
+package hlfq
+
+import (
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+// queuePushFront adds an item before the head of its priority band, so it's popped first among the band items.
+// Unlike Push the item is linked at once, so concurrent PushFront calls conflict on the head.
+// arg1 -> queueName string
+// arg2 -> newItemSpec QueueItemSpec
+func queuePushFront(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	spec := c.Param(newItemSpecParam).(QueueItemSpec)
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	if spec.ExtraData, err = itemExtraData(c, queue, spec.ExtraData, TransientExtraDataKey); err != nil {
+		return nil, err
+	}
+	rules, err := readValidationRules(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkItemSpec(spec, rules); err != nil {
+		return nil, err
+	}
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	creator, err := invokerActor(c)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newItemID(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make queue item")
+	}
+	curItem := makeQueueItem(queueName, spec, id, t, creator)
+	if err := setItemExtraData(c, queue, curItem, spec.ExtraData); err != nil {
+		return nil, err
+	}
+	// link CUR before the current head of the priority band, HEAD = CUR
+	if err := linkToHead(c, curItem); err != nil {
+		return nil, errors.Wrap(err, "failed to link pushed item")
+	}
+	if err := insertState(c, curItem); err != nil {
+		return nil, errors.Wrap(err, "failed to save pushed item")
+	}
+	addItemChange(c, ItemPushed, nil, curItem)
+	return curItem, nil
+}
+
+// queuePopBack reads and deletes the last available queue item (LIFO): the lowest priority band is served first,
+// each band from the tail. Items scheduled later than the tx time and reserved items are skipped and stay in place,
+// items which used all delivery attempts are left for Pop to dead-letter them.
+// arg1 -> queueName string
+func queuePopBack(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	var item QueueItem
+	found, empty := false, true
+	err = walkQueueBackward(c, queueName, func(cur QueueItem) (bool, error) {
+		empty = false
+		if cur.isAvailable(t) && !cur.isExhausted(t, queue.MaxDeliveryAttempts) {
+			item, found = cur, true
+		}
+		return found, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find ready item")
+	}
+	if empty {
+		return nil, ErrEmptyQueue
+	}
+	if !found {
+		return nil, ErrNoReadyItems
+	}
+
+	if _, err := cutItem(c, queueName, item.ID.String()); err != nil {
+		return nil, errors.Wrap(err, "failed to cut popped item")
+	}
+	if err := consumeItem(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to delete popped item")
+	}
+	addItemChange(c, ItemPopped, &item, nil)
+	return item, nil
+}
@@ -0,0 +1,127 @@
+package hlfq
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/extensions/encryption"
+	"github.com/s7techlab/cckit/router"
+)
+
+// ExtraData is encrypted with AES (cckit encryption extension) when Push, PushBatch, PushFront or AttachData
+// gets the key in the transient map under encryption.TransientMapKey. The key is never stored.
+// Every endorsing peer must write the same bytes, so the IV can't be random: it is the hash of the tx ID
+// and the item ID, and equal data of different items or txs gives different ciphertext.
+// The IV is the first block of the ciphertext, so encryption.Decrypt decrypts it.
+// Query methods decrypt ExtraData of returned items only if the caller passes the key the same way,
+// invoke responses are stored in the block, so they always keep the encrypted data.
+
+// transientEncryptionKey returns the encryption key passed in the transient map, nil if not passed
+func transientEncryptionKey(c router.Context) ([]byte, error) {
+	key, err := encryption.KeyFromTransient(c)
+	if err == encryption.ErrKeyNotDefinedInTransientMap {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get encryption key")
+	}
+	return key, nil
+}
+
+// encryptExtraData encrypts ExtraData of the item with the transient key if passed,
+// isEncrypted shows the data is encrypted
+func encryptExtraData(c router.Context, itemID string, data []byte) (encrypted []byte, isEncrypted bool, err error) {
+	key, err := transientEncryptionKey(c)
+	if err != nil || key == nil || len(data) == 0 {
+		return data, false, err
+	}
+	iv := sha256.Sum256([]byte(c.Stub().GetTxID() + itemID))
+	if encrypted, err = encryptCBC(key, iv[:aes.BlockSize], data); err != nil {
+		return nil, false, errors.Wrap(err, "failed to encrypt ExtraData")
+	}
+	return encrypted, true, nil
+}
+
+// encryptCBC encrypts PKCS7 padded data with AES CBC, returns the IV followed by the ciphertext
+func encryptCBC(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, aes.BlockSize+len(plain))
+	copy(encrypted, iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted[aes.BlockSize:], plain)
+	return encrypted, nil
+}
+
+// decryptResult is a router middleware decrypts encrypted ExtraData of items returned by the query method
+// if the caller passes the key in the transient map. Should be used by query methods only.
+func decryptResult(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		res, err := next(c)
+		if err != nil {
+			return res, err
+		}
+		key, err := transientEncryptionKey(c)
+		if err != nil || key == nil {
+			return res, err
+		}
+
+		switch r := res.(type) {
+		case QueueItem:
+			err = decryptItem(key, &r)
+			res = r
+		case []QueueItem:
+			err = decryptItems(key, r)
+		case ItemsPage:
+			err = decryptItems(key, r.Items)
+		case []interface{}: // Select result
+			for i := range r {
+				if item, ok := r[i].(QueueItem); ok && err == nil {
+					err = decryptItem(key, &item)
+					r[i] = item
+				}
+			}
+		case ArchivedItem:
+			err = decryptItem(key, &r.Item)
+			res = r
+		case []ArchivedItem:
+			for i := range r {
+				if err = decryptItem(key, &r[i].Item); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+}
+
+func decryptItems(key []byte, items []QueueItem) error {
+	for i := range items {
+		if err := decryptItem(key, &items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decryptItem decrypts ExtraData of the item if encrypted
+func decryptItem(key []byte, item *QueueItem) error {
+	if !item.ExtraDataEncrypted || len(item.ExtraData) == 0 {
+		return nil
+	}
+	data, err := encryption.Decrypt(key, item.ExtraData)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decrypt ExtraData of item '%s'", item.ID)
+	}
+	item.ExtraData, item.ExtraDataEncrypted = data, false
+	return nil
+}
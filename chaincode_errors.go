@@ -0,0 +1,72 @@
+package hlfq
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+	"github.com/s7techlab/cckit/state"
+)
+
+var (
+	// ErrEmptyQueue occurs when there are no items to pop or reserve in the queue
+	ErrEmptyQueue = errors.New("Empty queue")
+	// ErrNoReadyItems occurs when all items of the queue are scheduled later or reserved
+	ErrNoReadyItems = errors.New("No ready items in queue")
+)
+
+// Operations of StateError
+const (
+	StateGet    = "get"
+	StatePut    = "put"
+	StateInsert = "insert"
+	StateDelete = "delete"
+)
+
+// StateError is a failed read or write of the ledger state. A method returns it up to the router,
+// so the tx fails and a partly updated queue is never committed.
+// Use errors.Cause(err).(*StateError) to check the error type.
+type StateError struct {
+	Op  string
+	Key []string
+	Err error
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("state %s %v: %s", e.Op, e.Key, e.Err)
+}
+
+func newStateError(op string, entry interface{}, err error) error {
+	var key []string
+	switch k := entry.(type) {
+	case []string:
+		key = k
+	case state.StringsKeyer:
+		key, _ = k.Key()
+	}
+	return &StateError{Op: op, Key: key, Err: err}
+}
+
+// putState saves the entry, returns *StateError on failure
+func putState(c router.Context, entry state.StringsKeyer) error {
+	if err := c.State().Put(entry); err != nil {
+		return newStateError(StatePut, entry, err)
+	}
+	return nil
+}
+
+// insertState saves the new entry, fails if the entry exists, returns *StateError on failure
+func insertState(c router.Context, entry state.StringsKeyer) error {
+	if err := c.State().Insert(entry); err != nil {
+		return newStateError(StateInsert, entry, err)
+	}
+	return nil
+}
+
+// deleteState deletes the entry, returns *StateError on failure
+func deleteState(c router.Context, entry state.StringsKeyer) error {
+	if err := c.State().Delete(entry); err != nil {
+		return newStateError(StateDelete, entry, err)
+	}
+	return nil
+}
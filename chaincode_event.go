@@ -0,0 +1,101 @@
+package hlfq
+
+import (
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const (
+	// QueueEventName is the name of the chaincode event emitted by a tx which changed queue items
+	QueueEventName = "QueueChanged"
+	// context store key of the event collected during the tx
+	queueEventKey = "queueEvent"
+)
+
+// Item operations reported by the queue event
+const (
+	ItemPushed       = "Push"
+	ItemPopped       = "Pop"
+	ItemReserved     = "Reserve"
+	ItemAcked        = "Ack"
+	ItemNacked       = "Nack"
+	ItemDeadLettered = "DeadLetter"
+	ItemRequeued     = "Requeue"
+	ItemDeleted      = "Delete"
+	ItemRemoved      = "Remove"
+	ItemDataAttached = "AttachData"
+	ItemMoved        = "Move"
+	ItemRelinked     = "Relink"
+	ItemLinked       = "Link"
+)
+
+// ItemChange describes a change of one item, neighbours are item IDs, empty if none.
+// New neighbours are empty for a removed item.
+type ItemChange struct {
+	ItemID    string `json:"ItemID"`
+	Operation string `json:"Operation"`
+	OldPrevID string `json:"OldPrevID"`
+	OldNextID string `json:"OldNextID"`
+	NewPrevID string `json:"NewPrevID"`
+	NewNextID string `json:"NewNextID"`
+}
+
+// QueueEvent is the payload of the chaincode event.
+// Fabric keeps only one event per tx, so all item changes made by the tx are combined into one event.
+type QueueEvent struct {
+	QueueName string       `json:"QueueName"`
+	Method    string       `json:"Method"`
+	Submitter Actor        `json:"Submitter"`
+	Changes   []ItemChange `json:"Changes"`
+}
+
+// addItemChange adds the item change to the tx event,
+// old is nil for a new item, cur is nil for a removed item
+func addItemChange(c router.Context, operation string, old *QueueItem, cur *QueueItem) {
+	change := ItemChange{Operation: operation}
+	item := cur
+	if old != nil {
+		item = old
+		change.OldPrevID, change.OldNextID = keyItemID(old.PrevKey), keyItemID(old.NextKey)
+	}
+	if cur != nil {
+		change.NewPrevID, change.NewNextID = keyItemID(cur.PrevKey), keyItemID(cur.NextKey)
+	}
+	change.ItemID = item.ID.String()
+
+	event, _ := c.Get(queueEventKey).(*QueueEvent)
+	if event == nil {
+		event = &QueueEvent{QueueName: item.QueueName, Method: c.Path()}
+		c.Set(queueEventKey, event)
+	}
+	event.Changes = append(event.Changes, change)
+}
+
+// emitQueueEvent is a router middleware sets the event collected by the successful tx
+func emitQueueEvent(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		res, err := next(c)
+		if err != nil {
+			return res, err
+		}
+		event, _ := c.Get(queueEventKey).(*QueueEvent)
+		if event == nil {
+			return res, nil
+		}
+		if event.Submitter, err = invokerActor(c); err != nil {
+			return nil, err
+		}
+		if err := c.Event().Set(QueueEventName, event); err != nil {
+			return nil, errors.Wrap(err, "failed to set queue event")
+		}
+		return res, nil
+	}
+}
+
+// keyItemID returns item ID from the item key, empty string for the empty key
+func keyItemID(key []string) string {
+	if len(key) == 0 || isKeyEmpty(key) {
+		return ""
+	}
+	return key[len(key)-1]
+}
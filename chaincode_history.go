@@ -0,0 +1,86 @@
+package hlfq
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+	"github.com/s7techlab/cckit/state"
+)
+
+// ItemVersion is a committed change of the item state.
+// Pending is set for versions of the pending record written by Push, it's deleted when the item is linked.
+// Item is nil for a deletion: the item was consumed, removed or linked (for the pending record).
+type ItemVersion struct {
+	TxID      string     `json:"TxID"`
+	Timestamp time.Time  `json:"Timestamp"`
+	IsDelete  bool       `json:"IsDelete"`
+	Pending   bool       `json:"Pending"`
+	Item      *QueueItem `json:"Item"`
+}
+
+// queueItemHistory returns committed versions of the item sorted by the tx time,
+// it reads the ledger history, so the history database must be enabled on the peer
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueItemHistory(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	itemIDStr := c.ParamString(itemIDParam)
+	id, err := ulid.ParseStrict(itemIDStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ULID string passed")
+	}
+	versions := []ItemVersion{}
+	pendingVersions, err := readKeyHistory(c, pendingItem{QueueName: queueName, ID: id}, true)
+	if err != nil {
+		return nil, err
+	}
+	itemVersions, err := readKeyHistory(c, QueueItem{QueueName: queueName, ID: id}, false)
+	if err != nil {
+		return nil, err
+	}
+	versions = append(append(versions, pendingVersions...), itemVersions...)
+	// the pending record is deleted by the tx which stores the linked item, the stable sort keeps it first
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Timestamp.Before(versions[j].Timestamp)
+	})
+	return versions, nil
+}
+
+// readKeyHistory reads committed versions of the entry key
+func readKeyHistory(c router.Context, entry state.StringsKeyer, pending bool) ([]ItemVersion, error) {
+	key, _ := entry.Key()
+	compositeKey, err := c.Stub().CreateCompositeKey(key[0], key[1:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create item key")
+	}
+	iter, err := c.Stub().GetHistoryForKey(compositeKey)
+	if err != nil {
+		return nil, errors.Wrap(newStateError(StateGet, key, err), "failed to read item history")
+	}
+	defer iter.Close()
+
+	var versions []ItemVersion
+	for iter.HasNext() {
+		mod, err := iter.Next()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read item version")
+		}
+		version := ItemVersion{TxID: mod.TxId, IsDelete: mod.IsDelete, Pending: pending}
+		if ts := mod.Timestamp; ts != nil {
+			version.Timestamp = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+		}
+		if !mod.IsDelete {
+			item := &QueueItem{}
+			if err := json.Unmarshal(mod.Value, item); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode item version of tx '%s'", mod.TxId)
+			}
+			version.Item = item
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
@@ -1,7 +1,10 @@
 package hlfq
 
 import (
+	"encoding/json"
+
 	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/extensions/owner"
 	"github.com/s7techlab/cckit/router"
 )
 
@@ -12,19 +15,108 @@ import (
 // EmptyItemPointerKey HEAD or TAIL value when queue is empty
 var EmptyItemPointerKey []string = []string{"*EMPTY*"}
 
+// invokeInitLedger sets tx creator as the chaincode owner and creates the default queue,
+// it keeps existing owner and queues when called on upgrade and moves items of the single queue version
+// to the default queue
 func invokeInitLedger(c router.Context) (interface{}, error) {
-	var headPointer *QueuePointer = NewQueueHeadPointer()
-	headPointer.PointerKey = EmptyItemPointerKey
-	var tailPointer *QueuePointer = NewQueueTailPointer()
-	tailPointer.PointerKey = EmptyItemPointerKey
+	if _, err := owner.SetFromCreator(c); err != nil {
+		return nil, errors.Wrap(err, "failed to set chaincode owner")
+	}
 
-	// init place to store a head pointer
-	if err := c.State().Insert(headPointer); err != nil {
-		return nil, errors.Wrap(err, "failed to init head pointer store")
+	exists, err := c.State().Exists(Queue{Name: DefaultQueueName})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check default queue exists")
+	}
+	if !exists {
+		if _, err := createQueue(c, DefaultQueueName); err != nil {
+			return nil, errors.Wrap(err, "failed to create default queue")
+		}
 	}
-	// init place to store a tail pointer
-	if err := c.State().Insert(tailPointer); err != nil {
-		return nil, errors.Wrap(err, "failed to init tail pointer store")
+	if err := migrateLegacyItems(c); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate items to the default queue")
 	}
 	return nil, nil
 }
+
+// migrateLegacyItems moves items stored by the single queue version of the chaincode (item keys without
+// the queue name, one pair of head and tail pointers) to the lowest priority band of the default queue.
+// Items keep the order of the old list, items not reachable from the old head are appended in ID order.
+// Migrated items have no Creator, so only queue admins can change them.
+func migrateLegacyItems(c router.Context) error {
+	headKey := []string{queuePointerTypeName, headPointerName}
+	legacy, err := c.State().Exists(headKey) // the single queue version always has the head pointer
+	if err != nil {
+		return newStateError(StateGet, headKey, err)
+	}
+	if !legacy {
+		return nil
+	}
+
+	iter, err := c.Stub().GetStateByPartialCompositeKey(queueItemKeyPrefix, []string{})
+	if err != nil {
+		return errors.Wrap(err, "failed to read items")
+	}
+	defer iter.Close()
+	byKey := map[string]*QueueItem{}
+	var keys []string // in ID order
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return errors.Wrap(err, "failed to read item")
+		}
+		_, attrs, err := c.Stub().SplitCompositeKey(kv.Key)
+		if err != nil {
+			return errors.Wrap(err, "failed to split item key")
+		}
+		if len(attrs) != 1 { // keys of the current version have the queue name
+			continue
+		}
+		item := &QueueItem{}
+		if err := json.Unmarshal(kv.Value, item); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal item '%s'", attrs[0])
+		}
+		byKey[attrs[0]], keys = item, append(keys, attrs[0])
+	}
+
+	head, err := readQueuePointer(c, headKey)
+	if err != nil {
+		return err
+	}
+	// the old list order first, the old keys are [queueItemKeyPrefix, ID]
+	var items []*QueueItem
+	for key := head.PointerKey; len(key) == 2; {
+		item := byKey[key[1]]
+		if item == nil { // missing or already visited item, the rest is appended in ID order
+			break
+		}
+		items, byKey[key[1]] = append(items, item), nil
+		key = item.NextKey
+	}
+	for _, key := range keys {
+		if byKey[key] != nil {
+			items = append(items, byKey[key])
+		}
+	}
+
+	for _, item := range items {
+		key := []string{queueItemKeyPrefix, item.ID.String()}
+		if err := c.State().Delete(key); err != nil {
+			return errors.Wrap(newStateError(StateDelete, key, err), "failed to delete old item")
+		}
+		item.QueueName, item.Priority = DefaultQueueName, MinPriority
+	}
+	if err := linkAllToTail(c, itemList{QueueName: DefaultQueueName, Priority: MinPriority}, items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := insertState(c, item); err != nil {
+			return errors.Wrap(err, "failed to save migrated item")
+		}
+	}
+	for _, key := range [][]string{headKey, {queuePointerTypeName, tailPointerName}} {
+		if err := c.State().Delete(key); err != nil {
+			return errors.Wrap(newStateError(StateDelete, key, err), "failed to delete old pointer")
+		}
+	}
+	return nil
+}
@@ -2,12 +2,28 @@ package hlfq
 
 import (
 	"encoding/json"
+	"fmt"
 	"sort"
 
+	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
 	"github.com/s7techlab/cckit/router"
 )
 
+const (
+	startAfterIDParam = "startAfterID"
+	pageLimitParam    = "limit"
+	// MaxPageLimit is the max number of items returned by ListItemsPage
+	MaxPageLimit = 1000
+)
+
+// ItemsPage is a part of the queue items list.
+// Bookmark is the ID of the last item on the page to start the next page after, empty for the last page.
+type ItemsPage struct {
+	Items    []QueueItem `json:"Items"`
+	Bookmark string      `json:"Bookmark"`
+}
+
 // chaincode method handler
 func queueListItems(c router.Context) (interface{}, error) {
 	// we can raplace realization to any of queueListItems*
@@ -18,35 +34,93 @@ func queueListItems(c router.Context) (interface{}, error) {
 // ## Different ways of listing queue items
 // ###
 
-// gets a list form the ledger by travaling along Next links between nodes
+// gets a list form the ledger by travaling along Next links between nodes,
+// priority bands follow in the serving order, so the list shows the effective queue order
 func queueListItemsItarated(c router.Context) (interface{}, error) {
-	// TODO: take HEAD and iterate until NEXT != *EMPTY*[
 	items := []QueueItem{}
-	headPresent, _ := hasHead(c) // TODO: handle error
-	if !headPresent {
-		return items, nil // return empty list
-	}
-	head, _ := getHeadItem(c) // TODO: handle error
-	// fmt.Println("LIST Head ITEM=" + head.String())
-	// tail, _ := getTailItem(c) // TODO: handle error
-	// fmt.Println("LIST Tail ITEM=" + tail.String())
-	items = append(items, head)
-
-	nextKey := head.NextKey
-	for !isKeyEmpty(nextKey) {
-		item, err := readQueueItem(c, nextKey)
-		if err != nil {
-			return items, errors.Wrap(err, "failed read item to list")
-		}
+	err := walkQueue(c, c.ParamString(queueNameParam), func(item QueueItem) (bool, error) {
 		items = append(items, item)
-		nextKey = item.NextKey
+		return false, nil
+	})
+	if err != nil {
+		return items, errors.Wrap(err, "failed to list items")
 	}
 	return items, nil
 }
 
-// queueListItemsMemSorted read and return all queue items as list sorted by ULID stored in ID
+// queueListItemsPage returns up to limit items in the queue order following the item startAfterID,
+// empty startAfterID gives the first page. Items are read by links, so it works with any state DB.
+// If the item startAfterID is gone (popped or removed), the page starts at the first item in the queue order
+// with a greater ID: in a FIFO band it is the next item, items moved or pushed to the head may be repeated.
+// Pending records are read only by a page which gets past the linked items of a band.
+// arg1 -> queueName string
+// arg2 -> startAfterID string (ULID String or empty)
+// arg3 -> limit int
+func queueListItemsPage(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	startAfterIDStr := c.ParamString(startAfterIDParam)
+	limit := c.ParamInt(pageLimitParam)
+	if limit <= 0 || limit > MaxPageLimit {
+		return nil, errors.Errorf("Page limit must be from 1 to %d", MaxPageLimit)
+	}
+
+	page := ItemsPage{Items: []QueueItem{}}
+	more := false
+	collect := func(item QueueItem) (bool, error) {
+		if len(page.Items) == limit {
+			more = true // one more item exists
+			return true, nil
+		}
+		page.Items = append(page.Items, item)
+		return false, nil
+	}
+
+	var err error
+	if startAfterIDStr == "" {
+		err = walkQueue(c, queueName, collect)
+	} else {
+		startAfterID, parseErr := ulid.ParseStrict(startAfterIDStr)
+		if parseErr != nil {
+			return nil, errors.Wrap(parseErr, "failed to read bookmarked item: invalid ULID string passed")
+		}
+		exists, existsErr := itemExists(c, queueName, startAfterID)
+		if existsErr != nil {
+			return nil, errors.Wrap(existsErr, "failed to read bookmarked item")
+		}
+		if !exists {
+			// the bookmarked item is popped or removed, the page starts at the first item with a greater ID
+			started := false
+			err = walkQueue(c, queueName, func(item QueueItem) (bool, error) {
+				if !started && item.ID.Compare(startAfterID) <= 0 {
+					return false, nil
+				}
+				started = true
+				return collect(item)
+			})
+		} else {
+			startAfter, pending, readErr := readLinkedOrPendingItem(c, queueName, startAfterIDStr)
+			if readErr != nil {
+				return nil, errors.Wrap(readErr, "failed to read bookmarked item")
+			}
+			if startAfter.DeadLettered {
+				return nil, errors.Errorf("Bookmarked item is dead-lettered: %s", startAfterIDStr)
+			}
+			err = walkQueueAfter(c, startAfter, pending, collect)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list items page")
+	}
+	if more {
+		page.Bookmark = page.Items[len(page.Items)-1].ID.String()
+	}
+	return page, nil
+}
+
+// queueListItemsMemSorted read and return all queue items as list sorted by Priority and ULID stored in ID
 func queueListItemsMemSorted(c router.Context) (interface{}, error) {
-	res, err := c.State().List(queueItemKeyPrefix, &QueueItem{})
+	queueName := c.ParamString(queueNameParam)
+	res, err := c.State().List([]string{queueItemKeyPrefix, queueName}, &QueueItem{})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to list queue items")
 	}
@@ -59,6 +133,9 @@ func queueListItemsMemSorted(c router.Context) (interface{}, error) {
 	// fmt.Printf("queueListItems: unsorted items = %v\n\n", items)
 	sort.SliceStable(items, func(i, j int) bool {
 		//fmt.Printf("queueListItems: less %v, %v < %v\n\n", (items[i].ID.Compare(items[j].ID) < 0), items[i].ID.String(), items[j].ID.String())
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
 		return items[i].ID.Compare(items[j].ID) < 0
 	})
 	return items, nil
@@ -68,12 +145,13 @@ func queueListItemsMemSorted(c router.Context) (interface{}, error) {
 // NOTE: you can not test it by Mock, it doesn't implement GetQueryResult()
 func queueListItemsDBSorted(c router.Context) (interface{}, error) {
 	// сортировать по ID (т.к. это ULID отсортируются как по времени, первый будет самый старый)
-	queryString := `{
-        "selector": {},
+	queryString := fmt.Sprintf(`{
+        "selector": {"QueueName": %q},
         "sort": [
+            {"Priority": "desc"},
             {"id": "asc"}
         ]
-	}`
+	}`, c.ParamString(queueNameParam))
 
 	iter, err1 := c.Stub().GetQueryResult(queryString)
 	if err1 != nil {
@@ -100,5 +178,6 @@ func queueListItemsDBSorted(c router.Context) (interface{}, error) {
 
 // queueListItemsAsIs returns queue items in order they retreived from state DB (unexpected)
 func queueListItemsAsIs(c router.Context) (interface{}, error) {
-	return c.State().List(queueItemKeyPrefix, &QueueItem{})
+	queueName := c.ParamString(queueNameParam)
+	return c.State().List([]string{queueItemKeyPrefix, queueName}, &QueueItem{})
 }
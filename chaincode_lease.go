@@ -0,0 +1,110 @@
+package hlfq
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const leaseTimeoutParam = "leaseTimeout"
+
+// queueReserve leases the first available item to the tx creator for the timeout without removing it.
+// The item stays at its place but Pop and Reserve skip it until the lease expires,
+// the lease owner should Ack the item when processed or Nack it to make it available again.
+// Every Reserve counts a delivery attempt of the item.
+// arg1 -> queueName string
+// arg2 -> leaseTimeout int (seconds)
+func queueReserve(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	timeout := c.ParamInt(leaseTimeoutParam)
+	if timeout <= 0 {
+		return nil, errors.New("Lease timeout must be positive")
+	}
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	consumer, err := invokerActor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := firstAvailableItem(c, queueName, t, nil)
+	if err != nil {
+		return nil, err
+	}
+	item.LeaseOwner = consumer
+	item.LeaseExpires = t.UTC().Add(time.Duration(timeout) * time.Second)
+	item.DeliveryAttempts++
+	if err := putState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to save item lease")
+	}
+	addItemChange(c, ItemReserved, &item, &item)
+	return item, nil
+}
+
+// queueAck deletes the item reserved by the tx creator, returns the deleted item
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueAck(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	item, err := readLeasedItem(c, queueName, c.ParamString(itemIDParam))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cutItem(c, queueName, item.ID.String()); err != nil {
+		return nil, errors.Wrap(err, "failed to cut acknowledged item")
+	}
+	if err := consumeItem(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to delete acknowledged item")
+	}
+	addItemChange(c, ItemAcked, &item, nil)
+	return item, nil
+}
+
+// queueNack releases the item reserved by the tx creator, so it's available again at its position.
+// If the item used all delivery attempts it moves to the dead-letter list.
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueNack(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	item, err := readLeasedItem(c, queueName, c.ParamString(itemIDParam))
+	if err != nil {
+		return nil, err
+	}
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	if queue.MaxDeliveryAttempts > 0 && item.DeliveryAttempts >= queue.MaxDeliveryAttempts {
+		return moveToDeadLetters(c, item)
+	}
+	item.LeaseOwner = Actor{}
+	item.LeaseExpires = time.Time{}
+	if err := putState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to release item lease")
+	}
+	addItemChange(c, ItemNacked, &item, &item)
+	return item, nil
+}
+
+// readLeasedItem reads the item and checks it's reserved by the tx creator and the lease is not expired
+func readLeasedItem(c router.Context, queueName string, itemIDStr string) (item QueueItem, err error) {
+	t, err := c.Time()
+	if err != nil {
+		return item, errors.Wrap(err, "failed to get tx time")
+	}
+	consumer, err := invokerActor(c)
+	if err != nil {
+		return item, err
+	}
+	item, err = readQueueItemByID(c, queueName, itemIDStr)
+	if err != nil {
+		return item, err
+	}
+	if !item.isLeased(t) || item.LeaseOwner != consumer {
+		return item, errors.Errorf("Item is not reserved by the caller: %s", itemIDStr)
+	}
+	return item, nil
+}
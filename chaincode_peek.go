@@ -0,0 +1,92 @@
+package hlfq
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const peekCountParam = "n"
+
+// queuePeek returns the item Pop would return at the tx time without removing it
+// arg1 -> queueName string
+func queuePeek(c router.Context) (interface{}, error) {
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	items, err := peekAvailableItems(c, c.ParamString(queueNameParam), t, 1)
+	if err != nil {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// queuePeekN returns up to n items in the order Pop would return them at the tx time
+// arg1 -> queueName string
+// arg2 -> n int
+func queuePeekN(c router.Context) (interface{}, error) {
+	n := c.ParamInt(peekCountParam)
+	if n <= 0 || n > MaxPageLimit {
+		return nil, errors.Errorf("Peek count must be from 1 to %d", MaxPageLimit)
+	}
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	return peekAvailableItems(c, c.ParamString(queueNameParam), t, n)
+}
+
+// queuePeekTail returns the last item of the queue: the last pending item or the tail
+// of the lowest priority band which has items
+// arg1 -> queueName string
+func queuePeekTail(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	pending, err := readPendingByList(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	lists := queueLists(queueName)
+	for i := len(lists) - 1; i >= 0; i-- {
+		if n := len(pending[lists[i]]); n > 0 {
+			return pending[lists[i]][n-1], nil
+		}
+		present, err := hasTail(c, lists[i])
+		if err != nil {
+			return nil, err
+		}
+		if present {
+			return getTailItem(c, lists[i])
+		}
+	}
+	return nil, ErrEmptyQueue
+}
+
+// peekAvailableItems walks the queue from the head and returns up to n items available at the time t,
+// it skips the same items as firstAvailableItem but doesn't change the state
+func peekAvailableItems(c router.Context, queueName string, t time.Time, n int) ([]QueueItem, error) {
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	items := []QueueItem{}
+	empty := true
+	err = walkQueue(c, queueName, func(item QueueItem) (bool, error) {
+		empty = false
+		if item.isAvailable(t) && !item.isExhausted(t, queue.MaxDeliveryAttempts) {
+			items = append(items, item)
+		}
+		return len(items) == n, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to peek items")
+	}
+	if empty {
+		return nil, ErrEmptyQueue
+	}
+	if len(items) == 0 {
+		return nil, ErrNoReadyItems
+	}
+	return items, nil
+}
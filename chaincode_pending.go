@@ -0,0 +1,201 @@
+package hlfq
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+// Push doesn't touch the linked lists: every pushed item is stored as a pending record under its own key,
+// so concurrent pushes read and write no common keys and don't fail MVCC validation.
+// Pending items are linked to the tails of their bands by the next tx which changes the lists
+// (Pop, Reserve, Move, ...) or by Compact. Queries show pending items after the band tails.
+// The chaincode doesn't see the block order: pending items are ordered by the tx timestamp set by the client,
+// not by the commit order, a tx committed later with an earlier timestamp is linked first.
+
+const (
+	queuePendingKeyPrefix = "queuePendingKey"
+	// context store key of the queue name which pending items are linked by the tx
+	pendingLinkedKey = "pendingLinked"
+)
+
+// pendingItem is a pushed item not linked to its band yet
+type pendingItem QueueItem
+
+// Key for pendingItem entry in chaincode state
+func (p pendingItem) Key() ([]string, error) {
+	return []string{queuePendingKeyPrefix, p.QueueName, p.ID.String()}, nil
+}
+
+// queueCompact links pending items of the queue, returns the linked items
+// arg1 -> queueName string
+func queueCompact(c router.Context) (interface{}, error) {
+	return linkPendingItems(c, c.ParamString(queueNameParam))
+}
+
+// linkPending is a router middleware links pending items of the queue before the method changes the queue lists
+func linkPending(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		if _, err := linkPendingItems(c, c.ParamString(queueNameParam)); err != nil {
+			return nil, err
+		}
+		return next(c)
+	}
+}
+
+// linkPendingItems moves up to MaxBatchSize pending items of the queue with the lowest IDs to the tails
+// of their bands in tx time order, the tail of a band is updated once for all its pending items.
+// The rest of pending items wait for the next tx, the methods of this tx don't see them.
+func linkPendingItems(c router.Context, queueName string) ([]QueueItem, error) {
+	pending, err := readPendingRange(c, queueName, MaxBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(pendingLinkedKey, queueName)
+
+	byList := map[itemList][]*QueueItem{}
+	items := make([]*QueueItem, len(pending))
+	for i := range pending {
+		items[i] = &pending[i]
+		byList[items[i].list()] = append(byList[items[i].list()], items[i])
+	}
+	for _, l := range queueLists(queueName) {
+		if err := linkAllToTail(c, l, byList[l]); err != nil {
+			return nil, errors.Wrap(err, "failed to link pending items")
+		}
+	}
+
+	linked := make([]QueueItem, len(items))
+	for i, item := range items {
+		if err := deleteState(c, pendingItem(*item)); err != nil {
+			return nil, errors.Wrap(err, "failed to delete pending item")
+		}
+		countPending(c, *item, -1)
+		if err := insertState(c, item); err != nil {
+			return nil, errors.Wrap(err, "failed to save linked item")
+		}
+		addItemChange(c, ItemLinked, nil, item)
+		linked[i] = *item
+	}
+	return linked, nil
+}
+
+// readPendingItems returns all pending items of the queue, see readPendingRange
+func readPendingItems(c router.Context, queueName string) ([]QueueItem, error) {
+	return readPendingRange(c, queueName, 0)
+}
+
+// readPendingRange returns up to limit (0 - all) pending items of the queue with the lowest IDs
+// sorted by tx time, items of one tx by ULID, nothing if the tx has already linked pending items.
+// The range is read only up to the limit, so the tx doesn't depend on the items pushed later.
+// ULID keeps only milliseconds of the tx time, so the full tx time is compared first.
+func readPendingRange(c router.Context, queueName string, limit int) ([]QueueItem, error) {
+	if c.Get(pendingLinkedKey) == queueName {
+		return nil, nil
+	}
+	iter, err := stateStub(c).GetStateByPartialCompositeKey(queuePendingKeyPrefix, []string{queueName})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list pending items")
+	}
+	defer iter.Close()
+	items := []QueueItem{}
+	for iter.HasNext() && (limit == 0 || len(items) < limit) {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list pending items")
+		}
+		var item QueueItem
+		if err := json.Unmarshal(kv.Value, &item); err != nil {
+			return nil, errors.Wrap(err, "failed to decode pending item")
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedTime.Equal(items[j].CreatedTime) {
+			return items[i].CreatedTime.Before(items[j].CreatedTime)
+		}
+		return items[i].ID.Compare(items[j].ID) < 0
+	})
+	return items, nil
+}
+
+// readPendingByList returns pending items of the queue grouped by bands
+func readPendingByList(c router.Context, queueName string) (map[itemList][]QueueItem, error) {
+	items, err := readPendingItems(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	byList := map[itemList][]QueueItem{}
+	for _, item := range items {
+		byList[item.list()] = append(byList[item.list()], item)
+	}
+	return byList, nil
+}
+
+// lazyPendingByList returns the reader of pending items of the band, pending items are read on the first call,
+// so a walk stopped in the linked items doesn't read pending records
+func lazyPendingByList(c router.Context, queueName string) func(l itemList) ([]QueueItem, error) {
+	var byList map[itemList][]QueueItem
+	return func(l itemList) ([]QueueItem, error) {
+		if byList == nil {
+			var err error
+			if byList, err = readPendingByList(c, queueName); err != nil {
+				return nil, err
+			}
+		}
+		return byList[l], nil
+	}
+}
+
+// walkPending visits pending items until fn returns stop or error
+func walkPending(items []QueueItem, fn func(item QueueItem) (stop bool, err error)) (stopped bool, err error) {
+	for _, item := range items {
+		if stop, err := fn(item); stop || err != nil {
+			return stop, err
+		}
+	}
+	return false, nil
+}
+
+// itemExists checks the queue has the linked or pending item with the ID
+func itemExists(c router.Context, queueName string, id ulid.ULID) (bool, error) {
+	linkedKey, _ := QueueItem{QueueName: queueName, ID: id}.Key()
+	pendingKey, _ := pendingItem{QueueName: queueName, ID: id}.Key()
+	for _, key := range [][]string{linkedKey, pendingKey} {
+		exists, err := c.State().Exists(key)
+		if err != nil {
+			return false, newStateError(StateGet, key, err)
+		}
+		if exists {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readLinkedOrPendingItem reads the item by ID, pending shows the item is not linked yet
+func readLinkedOrPendingItem(c router.Context, queueName string, itemIDStr string) (item QueueItem, pending bool, err error) {
+	id, err := ulid.ParseStrict(itemIDStr)
+	if err != nil {
+		return item, false, errors.Wrap(err, "invalid ULID string passed")
+	}
+	linkedKey, _ := QueueItem{QueueName: queueName, ID: id}.Key()
+	linked, err := c.State().Exists(linkedKey)
+	if err != nil {
+		return item, false, newStateError(StateGet, linkedKey, err)
+	}
+	if linked {
+		item, err = readQueueItem(c, linkedKey)
+		return item, false, err
+	}
+	pendingKey, _ := pendingItem{QueueName: queueName, ID: id}.Key()
+	res, err := c.State().Get(pendingKey, &pendingItem{})
+	if err != nil {
+		return item, false, errors.Wrapf(newStateError(StateGet, pendingKey, err),
+			"failed to read QueueItem with ID '%s'", itemIDStr)
+	}
+	return QueueItem(res.(pendingItem)), true, nil
+}
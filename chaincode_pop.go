@@ -1,42 +1,143 @@
 package hlfq
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/s7techlab/cckit/router"
 )
 
-// queuePop read and delete the first queue item (the oldest, FIFO)
+const popCountParam = "n"
+
+// queuePop read and delete the first available queue item (the oldest, FIFO).
+// Items scheduled later than the tx time (NotBefore) and reserved items are skipped and stay in place.
 func queuePop(c router.Context) (extractedItem interface{}, err error) {
-	headPresent, _ := hasHead(c) // TODO: handle error
-	if !headPresent {
-		return extractedItem, errors.New("Empty queue")
-	}
-
-	headKey, _ := readHeadItemKey(c)                   // TODO: handle error
-	resHead, _ := c.State().Get(headKey, &QueueItem{}) // TODO: handle error
-	headItem := resHead.(QueueItem)
-
-	nextKey := EmptyItemPointerKey
-	// remove Prev link from nextItem if it exists
-	if headItem.hasNext() { // it's not a tail
-		// получить следующий элемент
-		nextKey = headItem.NextKey
-		// получить из State nextItem
-		resNext, _ := c.State().Get(nextKey, &QueueItem{}) // TODO: handle error
-		nextItem := resNext.(QueueItem)
-		// remove PrevKey from nextItem
-		nextItem.PrevKey = EmptyItemPointerKey
-		// save updated nextItem
-		c.State().Put(nextItem) // TODO: handle error
-	}
-	setHeadPointerTo(c, nextKey)
-	if isKeyEmpty(nextKey) { // reached a TailItem
-		setTailPointerTo(c, nextKey)
-	}
-	// remove extracted item from state
-	c.State().Delete(headKey) // TODO: handle error
-
-	//return item, c.State().Delete(item)
-	extractedItem = headItem
+	queueName := c.ParamString(queueNameParam)
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	item, err := popItem(c, queueName, t, nil)
+	if err != nil {
+		return nil, err
+	}
+	extractedItem = item
 	return extractedItem, nil
 }
+
+// queuePopN reads and deletes up to n first available items in one tx, returns them in the Pop order.
+// It fails like Pop if there are no items to pop.
+// The walk goes on from the last popped item, the items skipped before it can't become available in the same tx.
+// arg1 -> queueName string
+// arg2 -> n int
+func queuePopN(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	n := c.ParamInt(popCountParam)
+	if n <= 0 || n > MaxBatchSize {
+		return nil, errors.Errorf("Pop count must be from 1 to %d", MaxBatchSize)
+	}
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	items := []QueueItem{}
+	var after *QueueItem
+	for len(items) < n {
+		item, err := popItem(c, queueName, t, after)
+		if (err == ErrEmptyQueue || err == ErrNoReadyItems) && len(items) > 0 {
+			break // all available items popped
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		after = &item
+	}
+	return items, nil
+}
+
+// popItem cuts and deletes the first available item following the item after (nil - from the queue head),
+// the returned item keeps its links at the cut time
+func popItem(c router.Context, queueName string, t time.Time, after *QueueItem) (item QueueItem, err error) {
+	item, err = firstAvailableItem(c, queueName, t, after)
+	if err != nil {
+		return item, err
+	}
+
+	// unlink item from neighbours, it moves head and tail pointers if needed
+	if item, err = cutItem(c, queueName, item.ID.String()); err != nil {
+		return item, errors.Wrap(err, "failed to cut popped item")
+	}
+	// remove extracted item from state or move it to the archive
+	if err := consumeItem(c, item); err != nil {
+		return item, errors.Wrap(err, "failed to delete popped item")
+	}
+	addItemChange(c, ItemPopped, &item, nil)
+	return item, nil
+}
+
+// queueListScheduled returns items which are not ready to be popped at the tx time, in the queue order
+func queueListScheduled(c router.Context) (interface{}, error) {
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	items := []QueueItem{}
+	err = walkQueue(c, c.ParamString(queueNameParam), func(item QueueItem) (bool, error) {
+		if !item.isReady(t) {
+			items = append(items, item)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list scheduled items")
+	}
+	return items, nil
+}
+
+// firstAvailableItem returns the first item in the queue order allowed to be popped or reserved at the time t,
+// the walk starts after the item after or from the queue head if it is nil.
+// Items with expired lease which used all delivery attempts are moved to the dead-letter list on the way.
+func firstAvailableItem(c router.Context, queueName string, t time.Time, after *QueueItem) (readyItem QueueItem, err error) {
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return readyItem, err
+	}
+	walk := func(fn func(item QueueItem) (bool, error)) error {
+		if after == nil {
+			return walkQueue(c, queueName, fn)
+		}
+		return walkQueueAfter(c, *after, false, fn)
+	}
+	var exhausted []QueueItem
+	found, empty := false, true
+	err = walk(func(item QueueItem) (bool, error) {
+		empty = false
+		if item.isExhausted(t, queue.MaxDeliveryAttempts) {
+			exhausted = append(exhausted, item)
+			return false, nil
+		}
+		if item.isAvailable(t) {
+			readyItem, found = item, true
+		}
+		return found, nil
+	})
+	if err != nil {
+		return readyItem, errors.Wrap(err, "failed to find ready item")
+	}
+	changed, err := moveAllToDeadLetters(c, exhausted)
+	if err != nil {
+		return readyItem, err
+	}
+	if item, ok := changed[readyItem.ID.String()]; ok && found {
+		// the found item is a neighbour of the dead-lettered ones, it has new links
+		readyItem = item
+	}
+	if empty {
+		return readyItem, ErrEmptyQueue
+	}
+	if !found {
+		return readyItem, ErrNoReadyItems
+	}
+	return readyItem, nil
+}
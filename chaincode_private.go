@@ -0,0 +1,199 @@
+package hlfq
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+// ExtraData of a queue with a private data collection is kept in the collection, the public item keeps
+// the collection name and the SHA-256 hash of the salted data only. The data and the salt are passed
+// in the transient map, so they never get to the tx proposal stored in the block. The salt is kept
+// with the private data, without it short or guessable data could be found by its public hash.
+
+const (
+	privateExtraDataKeyPrefix = "privateExtraDataKey"
+	privateCollectionParam    = "collection"
+	privateReadersParam       = "readers"
+	// minExtraDataSaltLen is the minimal length of the ExtraData salt
+	minExtraDataSaltLen = 16
+	// TransientExtraDataKey is the transient map key of ExtraData for Push, PushFront and AttachData
+	// of a queue with a private collection. PushBatch takes ExtraData of the item #i by the key "ExtraData.<i>".
+	TransientExtraDataKey = "ExtraData"
+	// TransientExtraDataSaltKey is the transient map key of the salt of private ExtraData hash,
+	// all items of PushBatch use the same salt
+	TransientExtraDataSaltKey = "ExtraDataSalt"
+)
+
+// PrivateExtraData is ExtraData of the item kept in the private data collection
+type PrivateExtraData struct {
+	QueueName string `json:"QueueName"`
+	ItemID    string `json:"ItemID"`
+	ExtraData []byte `json:"ExtraData"`
+	Salt      []byte `json:"Salt"`
+}
+
+// Key for PrivateExtraData entry in the private state
+func (p PrivateExtraData) Key() ([]string, error) {
+	return []string{privateExtraDataKeyPrefix, p.QueueName, p.ItemID}, nil
+}
+
+// queueSetPrivateCollection sets the private data collection keeping ExtraData of new and changed items,
+// an empty collection name turns it off. The collection must be defined in the chaincode collections config.
+// Readers are the collection member MSPs, the chaincode can't read the collections config, so they are
+// set here. Keep readers when the collection is turned off, they still read data of the stored items.
+// arg1 -> queueName string
+// arg2 -> collection string
+// arg3 -> readers []string (MSP IDs)
+func queueSetPrivateCollection(c router.Context) (interface{}, error) {
+	queue, err := readQueue(c, c.ParamString(queueNameParam))
+	if err != nil {
+		return nil, err
+	}
+	queue.PrivateCollection = c.ParamString(privateCollectionParam)
+	queue.PrivateReaders = c.Param(privateReadersParam).([]string)
+	if queue.PrivateCollection != "" && len(queue.PrivateReaders) == 0 {
+		return nil, errors.Errorf("Private collection of queue '%s' must have readers", queue.Name)
+	}
+	if err := putState(c, queue); err != nil {
+		return nil, errors.Wrap(err, "failed to update queue")
+	}
+	return queue, nil
+}
+
+// queueGetPrivateExtraData returns private ExtraData of the item and its salt,
+// only clients of the queue reader MSPs can read it (and only peers of the collection member orgs have it)
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueGetPrivateExtraData(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	itemIDStr := c.ParamString(itemIDParam)
+	if err := checkPrivateReader(c, queueName); err != nil {
+		return nil, err
+	}
+	item, _, err := readLinkedOrPendingItem(c, queueName, itemIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if item.PrivateCollection == "" {
+		return nil, errors.Errorf("Item '%s' has no private ExtraData", itemIDStr)
+	}
+	private := PrivateExtraData{QueueName: queueName, ItemID: itemIDStr}
+	res, err := c.State().GetPrivate(item.PrivateCollection, private, &PrivateExtraData{})
+	if err != nil {
+		return nil, errors.Wrap(newStateError(StateGet, private, err), "failed to read private ExtraData")
+	}
+	private = res.(PrivateExtraData)
+	if extraDataHash(private.Salt, private.ExtraData) != item.ExtraDataHash {
+		return nil, errors.Errorf("Private ExtraData of item '%s' doesn't match its hash", itemIDStr)
+	}
+	// the data encrypted on Push is decrypted if the caller passes the key
+	key, err := transientEncryptionKey(c)
+	if err != nil || key == nil {
+		return private, err
+	}
+	item.ExtraData = private.ExtraData
+	if err := decryptItem(key, &item); err != nil {
+		return nil, err
+	}
+	private.ExtraData = item.ExtraData
+	return private, nil
+}
+
+// checkPrivateReader checks the MSP of the tx creator is a reader of the queue private ExtraData
+func checkPrivateReader(c router.Context, queueName string) error {
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return err
+	}
+	client, err := c.Client()
+	if err != nil {
+		return errors.Wrap(err, "failed to get tx creator")
+	}
+	mspID, err := client.GetMSPID()
+	if err != nil {
+		return errors.Wrap(err, "failed to get tx creator MSP")
+	}
+	for _, reader := range queue.PrivateReaders {
+		if reader == mspID {
+			return nil
+		}
+	}
+	return errors.Errorf("%s: MSP '%s' can not read private ExtraData of queue '%s'", ErrAccessDenied, mspID, queueName)
+}
+
+// itemExtraData returns ExtraData of the item passed to the method: the transient map value by the key
+// for a queue with a private collection (public ExtraData is refused there), the public value otherwise
+func itemExtraData(c router.Context, queue Queue, public []byte, transientKey string) ([]byte, error) {
+	if queue.PrivateCollection == "" {
+		return public, nil
+	}
+	if len(public) > 0 {
+		return nil, errors.Errorf("ExtraData of queue '%s' must be passed in the transient map", queue.Name)
+	}
+	transient, err := c.Stub().GetTransient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get transient map")
+	}
+	return transient[transientKey], nil
+}
+
+// batchTransientKey is the transient map key of ExtraData of the PushBatch item #i
+func batchTransientKey(i int) string {
+	return fmt.Sprintf("%s.%d", TransientExtraDataKey, i)
+}
+
+// setItemExtraData replaces ExtraData of the item, the data is encrypted if the key is passed
+// and goes to the private collection of the queue if set with the salt from the transient map.
+// The item is saved by the caller.
+func setItemExtraData(c router.Context, queue Queue, item *QueueItem, data []byte) error {
+	if err := deletePrivateExtraData(c, *item); err != nil {
+		return err
+	}
+	data, encrypted, err := encryptExtraData(c, item.ID.String(), data)
+	if err != nil {
+		return err
+	}
+	item.ExtraData, item.PrivateCollection, item.ExtraDataHash = data, "", ""
+	item.ExtraDataEncrypted = encrypted
+	if queue.PrivateCollection == "" || len(data) == 0 {
+		return nil
+	}
+	transient, err := c.Stub().GetTransient()
+	if err != nil {
+		return errors.Wrap(err, "failed to get transient map")
+	}
+	salt := transient[TransientExtraDataSaltKey]
+	if len(salt) < minExtraDataSaltLen {
+		return errors.Errorf("ExtraData salt of at least %d bytes must be passed in the transient map",
+			minExtraDataSaltLen)
+	}
+	private := PrivateExtraData{QueueName: item.QueueName, ItemID: item.ID.String(), ExtraData: data, Salt: salt}
+	if err := c.State().PutPrivate(queue.PrivateCollection, private); err != nil {
+		return errors.Wrap(newStateError(StatePut, private, err), "failed to save private ExtraData")
+	}
+	item.ExtraData, item.PrivateCollection = nil, queue.PrivateCollection
+	item.ExtraDataHash = extraDataHash(salt, data)
+	return nil
+}
+
+// deletePrivateExtraData deletes private ExtraData of the deleted item
+func deletePrivateExtraData(c router.Context, item QueueItem) error {
+	if item.PrivateCollection == "" {
+		return nil
+	}
+	private := PrivateExtraData{QueueName: item.QueueName, ItemID: item.ID.String()}
+	if err := c.State().DeletePrivate(item.PrivateCollection, private); err != nil {
+		return errors.Wrap(newStateError(StateDelete, private, err), "failed to delete private ExtraData")
+	}
+	return nil
+}
+
+// extraDataHash returns hex SHA-256 hash of the salt followed by the data
+func extraDataHash(salt, data []byte) string {
+	hash := sha256.Sum256(append(append([]byte{}, salt...), data...))
+	return hex.EncodeToString(hash[:])
+}
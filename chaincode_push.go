@@ -1,10 +1,10 @@
 package hlfq
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"time"
 
-	cryptorand "crypto/rand"
-
 	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
 	"github.com/s7techlab/cckit/router"
@@ -14,63 +14,166 @@ import (
 // ** Chaincode method **
 // **
 
-const newItemSpecParam = "newItemSpec"
+const (
+	newItemSpecParam  = "newItemSpec"
+	newItemSpecsParam = "newItemSpecs"
+	// MaxBatchSize is the max number of items pushed or popped in one tx
+	MaxBatchSize = 100
+	// context store key of the items counter, used to make IDs unique inside one tx
+	itemCounterKey = "itemCounter"
+)
 
-// queuePush adds an item after last queue item
+// queuePush adds an item after last queue item.
+// The item is stored as a pending record and linked to the tail of its band by the next tx changing the lists,
+// so concurrent pushes don't conflict on the tail.
 func queuePush(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
 	spec := c.Param(newItemSpecParam).(QueueItemSpec)
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	if spec.ExtraData, err = itemExtraData(c, queue, spec.ExtraData, TransientExtraDataKey); err != nil {
+		return nil, err
+	}
+	rules, err := readValidationRules(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkItemSpec(spec, rules); err != nil {
+		return nil, err
+	}
 	// getTxTimestamp() - time when transaction proposial was created
-	t, _ := c.Time()                     // tx time // TODO: handle get txt time error
-	curItem, _ := makeQueueItem(spec, t) // TODO: handle assign errors
-	curItemKey, _ := curItem.Key()       // TODO: handle read error
-
-	tailPresent, _ := hasTail(c) // TODO: handle read error
-	if tailPresent {
-		tailItem, _ := getTailItem(c) // TODO: handle read error
-		tailItem.NextKey = curItemKey // TAIL->CUR
-		tailKey, _ := tailItem.Key()  // TODO: handle read error
-		curItem.PrevKey = tailKey     // TAIL<-CUR
-		// update prvious tail item
-		c.State().Put(tailItem) // TODO: handle errors
+	t, err := c.Time() // tx time
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	creator, err := invokerActor(c)
+	if err != nil {
+		return nil, err
 	}
+	id, err := newItemID(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make queue item")
+	}
+	curItem := makeQueueItem(queueName, spec, id, t, creator)
+	if err := setItemExtraData(c, queue, curItem, spec.ExtraData); err != nil {
+		return nil, err
+	}
+	// insert return an error if item already exists
+	if err := insertState(c, pendingItem(*curItem)); err != nil {
+		return nil, errors.Wrap(err, "failed to save pushed item")
+	}
+	countPending(c, *curItem, 1)
+	addItemChange(c, ItemPushed, nil, curItem)
+	return curItem, nil
+}
 
-	// set CUR as tail / replce tail kay with new one
-	setTailPointerTo(c, curItemKey) // TAIL = CUR
+// queuePushBatch adds items after the last queue items in one tx, returns pushed items in the order of specs.
+// Items are stored as pending records like pushed by Push, the order of specs is kept by ULIDs.
+// arg1 -> queueName string
+// arg2 -> newItemSpecs []QueueItemSpec
+func queuePushBatch(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	specs := c.Param(newItemSpecsParam).([]QueueItemSpec)
+	if len(specs) == 0 || len(specs) > MaxBatchSize {
+		return nil, errors.Errorf("Batch size must be from 1 to %d", MaxBatchSize)
+	}
+	queue, err := readQueue(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := readValidationRules(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range specs {
+		if specs[i].ExtraData, err = itemExtraData(c, queue, specs[i].ExtraData, batchTransientKey(i)); err != nil {
+			return nil, errors.Wrapf(err, "invalid item #%d", i)
+		}
+		if err := checkItemSpec(specs[i], rules); err != nil {
+			return nil, errors.Wrapf(err, "invalid item #%d", i)
+		}
+	}
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	creator, err := invokerActor(c)
+	if err != nil {
+		return nil, err
+	}
 
-	// UPDATE Head key if head not set
-	headPresent, _ := hasHead(c) // TODO: handle error
-	if !headPresent {
-		// c.Logger().Debug("*** headNotPresent")
-		// set head pointer to CUR
-		setHeadPointerTo(c, curItemKey) // TODO: handle store write error
-	}
-	// printout updated states
-	// h1, _ := readHeadItemKey(c)
-	// t1, _ := readTailItemKey(c)
-	// fmt.Printf("queuePush head: %+v\n", h1)
-	// fmt.Printf("queuePush tail: %+v\n", t1)
+	pushed := make([]QueueItem, len(specs))
+	for i, spec := range specs {
+		id, err := newItemID(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to make queue item")
+		}
+		item := makeQueueItem(queueName, spec, id, t, creator)
+		if err := setItemExtraData(c, queue, item, spec.ExtraData); err != nil {
+			return nil, err
+		}
+		if err := insertState(c, pendingItem(*item)); err != nil {
+			return nil, errors.Wrap(err, "failed to save pushed item")
+		}
+		countPending(c, *item, 1)
+		addItemChange(c, ItemPushed, nil, item)
+		pushed[i] = *item
+	}
+	return pushed, nil
+}
 
-	// insert return an error if item already exists
-	return curItem, c.State().Insert(curItem)
+// checkItemSpec validates the new item, then checks validation rules of the queue
+func checkItemSpec(spec QueueItemSpec, rules ValidationRules) error {
+	if spec.Priority < MinPriority || spec.Priority > MaxPriority {
+		return errors.Errorf("Priority must be from %d to %d", MinPriority, MaxPriority)
+	}
+	return rules.validate(spec)
 }
 
-func makeQueueItem(spec QueueItemSpec, t time.Time) (*QueueItem, error) {
-	entropy := cryptorand.Reader
-	id, err1 := ulid.New(ulid.Timestamp(time.Now()), entropy)
-	if err1 != nil {
-		return nil, errors.Wrap(err1, "failed generate item UID")
+// newItemID generates ULID for a new item from the data shared by all endorsing peers:
+// tx timestamp gives the time part, tx ID hash and per-tx counter give the entropy part.
+// So every peer gets the same ID for the same tx, and IDs made in one tx are ordered.
+func newItemID(c router.Context) (id ulid.ULID, err error) {
+	t, err := c.Time()
+	if err != nil {
+		return id, errors.Wrap(err, "failed to get tx time for item ID")
 	}
+	counter, _ := c.Get(itemCounterKey).(uint16)
+	c.Set(itemCounterKey, counter+1)
+
+	// 80 bit of entropy: 8 bytes of tx ID hash + 2 bytes of counter
+	txHash := sha256.Sum256([]byte(c.Stub().GetTxID()))
+	var entropy [10]byte
+	copy(entropy[:8], txHash[:8])
+	binary.BigEndian.PutUint16(entropy[8:], counter)
+
+	if err = id.SetTime(ulid.Timestamp(t)); err != nil {
+		return id, errors.Wrap(err, "failed to set item ID time")
+	}
+	if err = id.SetEntropy(entropy[:]); err != nil {
+		return id, errors.Wrap(err, "failed to set item ID entropy")
+	}
+	return id, nil
+}
+
+func makeQueueItem(queueName string, spec QueueItemSpec, id ulid.ULID, t time.Time, creator Actor) *QueueItem {
 	// data for chaincode state
 	item := &QueueItem{
+		QueueName:   queueName,
 		ID:          id,
 		From:        spec.From,
 		To:          spec.To,
 		Amount:      spec.Amount,
+		Priority:    spec.Priority,
+		NotBefore:   spec.NotBefore,
 		ExtraData:   spec.ExtraData,
-		CreatedTime: t,
+		Payload:     spec.Payload,
+		CreatedTime: t.UTC(), // peers may run in different time zones
+		Creator:     creator,
 		NextKey:     EmptyItemPointerKey,
 		PrevKey:     EmptyItemPointerKey,
 	}
-	return item, nil
-
+	return item
 }
@@ -0,0 +1,143 @@
+package hlfq
+
+import (
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+// DefaultQueueName is a name of the queue created at chaincode init
+const DefaultQueueName = "default"
+
+const maxDeliveryAttemptsParam = "maxDeliveryAttempts"
+
+// queueCreate registers a new empty queue, returns error if queue already exists
+// arg1 -> queueName string
+func queueCreate(c router.Context) (interface{}, error) {
+	return createQueue(c, c.ParamString(queueNameParam))
+}
+
+// queueDelete deletes the queue with all its items
+// arg1 -> queueName string
+func queueDelete(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	res, err := c.State().List([]string{queueItemKeyPrefix, queueName}, &QueueItem{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list queue items to delete")
+	}
+	for _, i := range res.([]interface{}) {
+		item := i.(QueueItem)
+		if err := deleteState(c, item); err != nil {
+			return nil, errors.Wrap(err, "failed to delete queue item")
+		}
+		if err := deletePrivateExtraData(c, item); err != nil {
+			return nil, err
+		}
+		addItemChange(c, ItemDeleted, &item, nil)
+	}
+	pending, err := readPendingItems(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pending {
+		if err := deleteState(c, pendingItem(pending[i])); err != nil {
+			return nil, errors.Wrap(err, "failed to delete pending item")
+		}
+		countPending(c, pending[i], -1)
+		if err := deletePrivateExtraData(c, pending[i]); err != nil {
+			return nil, err
+		}
+		addItemChange(c, ItemDeleted, &pending[i], nil)
+	}
+	for _, l := range append(queueLists(queueName), deadLetterList(queueName)) {
+		if err := deleteState(c, l.headPointer()); err != nil {
+			return nil, errors.Wrap(err, "failed to delete head pointer")
+		}
+		if err := deleteState(c, l.tailPointer()); err != nil {
+			return nil, errors.Wrap(err, "failed to delete tail pointer")
+		}
+		if err := deleteListStats(c, l); err != nil {
+			return nil, errors.Wrap(err, "failed to delete list stats")
+		}
+	}
+	if err := deleteArchive(c, queueName); err != nil {
+		return nil, err
+	}
+	if err := deleteState(c, ValidationRules{QueueName: queueName}); err != nil {
+		return nil, errors.Wrap(err, "failed to delete validation rules")
+	}
+	queue := Queue{Name: queueName}
+	return queue, deleteState(c, queue)
+}
+
+// queueListQueues returns all registered queues
+func queueListQueues(c router.Context) (interface{}, error) {
+	res, err := c.State().List(queueKeyPrefix, &Queue{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list queues")
+	}
+	queues := []Queue{}
+	for _, q := range res.([]interface{}) {
+		queues = append(queues, q.(Queue))
+	}
+	return queues, nil
+}
+
+// createQueue stores the queue entry, head and tail pointers of priority bands are stored on demand
+func createQueue(c router.Context, queueName string) (*Queue, error) {
+	if queueName == "" {
+		return nil, errors.New("Empty queue name")
+	}
+	t, err := c.Time()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tx time")
+	}
+	queue := &Queue{Name: queueName, CreatedTime: t.UTC()}
+	if err := insertState(c, queue); err != nil {
+		return nil, errors.Wrapf(err, "failed to create queue '%s'", queueName)
+	}
+	return queue, nil
+}
+
+// queueMustExist is a middleware allows to call the method only for a registered queue,
+// should follow the queueNameParam definition
+func queueMustExist(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		queueName := c.ParamString(queueNameParam)
+		exists, err := c.State().Exists(Queue{Name: queueName})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check queue '%s' exists", queueName)
+		}
+		if !exists {
+			return nil, errors.Errorf("Queue '%s' not exists", queueName)
+		}
+		return next(c)
+	}
+}
+
+// queueSetMaxDeliveryAttempts sets the number of Reserve attempts after which a not acknowledged item
+// moves to the dead-letter list, 0 means unlimited
+// arg1 -> queueName string
+// arg2 -> maxDeliveryAttempts int
+func queueSetMaxDeliveryAttempts(c router.Context) (interface{}, error) {
+	maxAttempts := c.ParamInt(maxDeliveryAttemptsParam)
+	if maxAttempts < 0 {
+		return nil, errors.New("Max delivery attempts must not be negative")
+	}
+	queue, err := readQueue(c, c.ParamString(queueNameParam))
+	if err != nil {
+		return nil, err
+	}
+	queue.MaxDeliveryAttempts = maxAttempts
+	if err := putState(c, queue); err != nil {
+		return nil, errors.Wrap(err, "failed to update queue")
+	}
+	return queue, nil
+}
+
+func readQueue(c router.Context, queueName string) (queue Queue, err error) {
+	res, err := c.State().Get(Queue{Name: queueName}, &Queue{})
+	if err != nil {
+		return queue, errors.Wrapf(err, "failed to read queue '%s'", queueName)
+	}
+	return res.(Queue), nil
+}
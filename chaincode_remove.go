@@ -0,0 +1,27 @@
+package hlfq
+
+import (
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+// queueRemove unlinks the item from its list and deletes it, returns the removed item.
+// Head and tail pointers are moved if the item is a head or tail item.
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+func queueRemove(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	itemIDStr := c.ParamString(itemIDParam)
+	item, err := cutItem(c, queueName, itemIDStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to cut item ID '%s'", itemIDStr)
+	}
+	if err := deleteState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to delete removed item")
+	}
+	if err := deletePrivateExtraData(c, item); err != nil {
+		return nil, err
+	}
+	addItemChange(c, ItemRemoved, &item, nil)
+	return item, nil
+}
@@ -6,34 +6,42 @@ import (
 )
 
 // queueMoveAfter cuts item and puts it after specified item ID.
+// Item moves to the priority band of the afterItem.
 // returns an updated item (should have updated prev/next links)
 //   or error if itemID or afterItemID not exists
-// arg1 -> itemID string (ULID String)
-// arg2 -> afterItemID string (ULID String)
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+// arg3 -> afterItemID string (ULID String)
 func queueMoveAfter(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
 	itemIDStr := c.ParamString(itemIDParam)
 	afterItemIDStr := c.ParamString(afterItemIDParam)
 	if itemIDStr == afterItemIDStr {
 		return nil, errors.New("Can not move an item after itself")
 	}
+	if err := checkSameList(c, queueName, itemIDStr, afterItemIDStr); err != nil {
+		return nil, err
+	}
 
 	// cut item and reconnect neighbours
-	item, err := cutItem(c, itemIDStr)
+	item, err := cutItem(c, queueName, itemIDStr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to cut item ID '%s'", itemIDStr)
 	}
 	itemKey, _ := item.Key()
+	old := item
 
 	// fmt.Printf("\n\n** queueMoveAfter :: CUT_ITEM=%s\n\n", item.String())
 	// reset links
 	item.PrevKey = EmptyItemPointerKey
 	item.NextKey = EmptyItemPointerKey
 
-	afterItem, err := readQueueItemByID(c, afterItemIDStr)
+	afterItem, err := readQueueItemByID(c, queueName, afterItemIDStr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed load afterItem ID '%s'", afterItemIDStr)
 	}
 
+	item.Priority = afterItem.Priority
 	afterItemKey, _ := afterItem.Key()
 	if afterItem.hasNext() {
 		// need to update Prev in afterNext item
@@ -48,17 +56,22 @@ func queueMoveAfter(c router.Context) (interface{}, error) {
 		// connect: item -[next]-> afterNext
 		item.NextKey = afterItemNextKey
 		// save link update of afterItemNext
-		c.State().Put(afterItemNext) // TODO: handle error
+		if err := putState(c, afterItemNext); err != nil {
+			return nil, errors.Wrap(err, "failed to save afterItemNext")
+		}
 	}
 
 	// Update the Tail pointer if we paste after the tail item
 	// check if item is a Tail, so we need to replace TailPointer
-
-	if isTailPointsTo(c, afterItem) { // pasting after tail item
+	isTail, err := isTailPointsTo(c, afterItem)
+	if err != nil {
+		return nil, err
+	}
+	if isTail { // pasting after tail item
 		// item now is new tail
-		setTailPointerTo(c, itemKey) // TODO: handle error
-		// tailItem2, _ := getTailItem(c)
-		// fmt.Printf("queueMoveAfter::--> NEW TailID=%s\n", tailItem2.ID.String())
+		if err := setTailPointerTo(c, afterItem.list(), itemKey); err != nil {
+			return nil, err
+		}
 	}
 
 	// connect: afterItem -[next]-> item
@@ -68,41 +81,55 @@ func queueMoveAfter(c router.Context) (interface{}, error) {
 	item.PrevKey = afterItemKey
 
 	// save link update of item. Item now between after and afterNext items
-	c.State().Put(item)      // TODO: handle error
-	c.State().Put(afterItem) // TODO: handle error
+	if err := putState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to save moved item")
+	}
+	if err := putState(c, afterItem); err != nil {
+		return nil, errors.Wrap(err, "failed to save afterItem")
+	}
+	countItem(c, item.list(), item, 1)
+	addItemChange(c, ItemMoved, &old, &item)
 
 	return item, nil
 }
 
 // queueMoveBefore cuts item and puts it before specified item ID.
+// Item moves to the priority band of the beforeItem.
 // returns an updated item (should have updated prev/next links)
 //   error if itemID or afterItemID not exists
-// arg1 -> itemID string (ULID String)
-// arg2 -> beforeItemID string (ULID String)
+// arg1 -> queueName string
+// arg2 -> itemID string (ULID String)
+// arg3 -> beforeItemID string (ULID String)
 func queueMoveBefore(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
 	itemIDStr := c.ParamString(itemIDParam)
 	beforeItemIDStr := c.ParamString(beforeItemIDParam)
 	if itemIDStr == beforeItemIDStr {
 		return nil, errors.New("Can not move an item before itself")
 	}
+	if err := checkSameList(c, queueName, itemIDStr, beforeItemIDStr); err != nil {
+		return nil, err
+	}
 
 	// cut item and reconnect neighbours
-	item, err := cutItem(c, itemIDStr)
+	item, err := cutItem(c, queueName, itemIDStr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to cut item ID '%s'", itemIDStr)
 	}
 	itemKey, _ := item.Key()
+	old := item
 
 	// fmt.Printf("\n\n** queueMoveBefore:: CUT_ITEM=%s\n\n", item.String())
 	// reset links
 	item.PrevKey = EmptyItemPointerKey
 	item.NextKey = EmptyItemPointerKey
 
-	beforeItem, err := readQueueItemByID(c, beforeItemIDStr)
+	beforeItem, err := readQueueItemByID(c, queueName, beforeItemIDStr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed load beforeItem ID '%s'", beforeItemIDStr)
 	}
 
+	item.Priority = beforeItem.Priority
 	beforeItemKey, _ := beforeItem.Key()
 	if beforeItem.hasPrev() {
 		// need to update Next in beforePrev item
@@ -118,18 +145,22 @@ func queueMoveBefore(c router.Context) (interface{}, error) {
 		// connect: item <-[prev]- beforePrev
 		item.PrevKey = beforeItemPrevKey
 		// save link update of beforeItemPrev
-		c.State().Put(beforeItemPrev) // TODO: handle error
+		if err := putState(c, beforeItemPrev); err != nil {
+			return nil, errors.Wrap(err, "failed to save beforeItemPrev")
+		}
 	}
 
 	// Update the Head pointer if we paste before the head item
 	// check if item is a Head, so we need to replace HeadPointer
-	if isHeadPointsTo(c, beforeItem) { // TODO: handle error
-		// fmt.Println("queueMoveBefore:: beforeItem is HEAD --->[...]")
-		// fmt.Printf("queueMoveBefore::***OLD HeadID=%s\n", beforeItem.ID.String())
-		// set head pointer to next item (list=X[head]<->Y => list=Y[Head], cut=X)
-		setHeadPointerTo(c, itemKey) // TODO: handle error
-		// headItem2, _ := getHeadItem(c) // TODO: handle error
-		// fmt.Printf("queueMoveBefore::***NEW HeadID=%s\n", headItem2.ID.String())
+	isHead, err := isHeadPointsTo(c, beforeItem)
+	if err != nil {
+		return nil, err
+	}
+	if isHead {
+		// item now is new head
+		if err := setHeadPointerTo(c, beforeItem.list(), itemKey); err != nil {
+			return nil, err
+		}
 	}
 
 	// connect:  <-[prev]- item
@@ -139,8 +170,32 @@ func queueMoveBefore(c router.Context) (interface{}, error) {
 	item.NextKey = beforeItemKey
 
 	// save link update of item. Item now between beforeItemPrev and beforeItem items
-	c.State().Put(item)       // TODO: handle error
-	c.State().Put(beforeItem) // TODO: handle error
+	if err := putState(c, item); err != nil {
+		return nil, errors.Wrap(err, "failed to save moved item")
+	}
+	if err := putState(c, beforeItem); err != nil {
+		return nil, errors.Wrap(err, "failed to save beforeItem")
+	}
+	countItem(c, item.list(), item, 1)
+	addItemChange(c, ItemMoved, &old, &item)
 
 	return item, nil
 }
+
+// checkSameList fails the move of the item next to the target item on another list:
+// items move between priority bands, but not between the queue and the dead-letter list.
+// Missing items are reported by the move.
+func checkSameList(c router.Context, queueName string, itemIDStr string, targetIDStr string) error {
+	item, err := readQueueItemByID(c, queueName, itemIDStr)
+	if err != nil {
+		return nil
+	}
+	target, err := readQueueItemByID(c, queueName, targetIDStr)
+	if err != nil {
+		return nil
+	}
+	if item.DeadLettered != target.DeadLettered {
+		return errors.New("Can not move an item between the queue and the dead-letter list")
+	}
+	return nil
+}
@@ -11,7 +11,7 @@ import (
 const selectQueryStringParam = "queryString"
 
 // Select get elemets specified by CouchDB query
-// arg1 =`queryString` - query in `expr` syntax
+// arg1 =`queryString` - query in `expr` syntax, payload fields are accessed as `.Payload.field`
 // returns error query syntax is invalid
 func queueSelect(c router.Context) (interface{}, error) {
 	queryStr := c.ParamString(selectQueryStringParam)
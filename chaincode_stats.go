@@ -0,0 +1,248 @@
+package hlfq
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const (
+	listStatsKeyPrefix    = "listStatsKey"
+	pendingStatsKeyPrefix = "pendingStatsKey"
+	// context store keys of stats changes made by the tx
+	listStatsDeltaKey    = "listStatsDelta"
+	pendingStatsDeltaKey = "pendingStatsDelta"
+)
+
+// ListStats holds counters of one item list (priority band or dead-letter list).
+// Counters are kept per list, so Push and Pop write only the stats of the band which pointers they already change,
+// and txs working with different bands don't conflict on one stats record.
+type ListStats struct {
+	QueueName  string `json:"QueueName"`
+	Priority   int    `json:"Priority"`
+	DeadLetter bool   `json:"DeadLetter"`
+	Count      int    `json:"Count"`
+	AmountSum  int    `json:"AmountSum"`
+}
+
+// Key for ListStats entry in chaincode state
+func (s ListStats) Key() ([]string, error) {
+	return []string{listStatsKeyPrefix, s.QueueName, s.list().name()}, nil
+}
+
+func (s ListStats) list() itemList {
+	return itemList{QueueName: s.QueueName, Priority: s.Priority, DeadLetter: s.DeadLetter}
+}
+
+// pendingStats counts pending items pushed by one tx. Push writes it under a key of its own like the pending items,
+// so concurrent pushes don't conflict on a shared counter. The tx linking pending items subtracts them,
+// the linked items are counted by ListStats of their bands.
+type pendingStats struct {
+	QueueName   string    `json:"QueueName"`
+	TxItemID    string    `json:"TxItemID"` // ID of the first item pushed by the tx
+	Count       int       `json:"Count"`
+	AmountSum   int       `json:"AmountSum"`
+	CreatedTime time.Time `json:"CreatedTime"` // tx time of the push
+}
+
+// Key for pendingStats entry in chaincode state
+func (s pendingStats) Key() ([]string, error) {
+	return []string{pendingStatsKeyPrefix, s.QueueName, s.TxItemID}, nil
+}
+
+// txItemID returns the ID of the first item pushed by the tx of the item,
+// items of one tx differ only by the counter in the last 2 bytes of the ID (see newItemID)
+func txItemID(id ulid.ULID) string {
+	id[14], id[15] = 0, 0
+	return id.String()
+}
+
+// QueueStats is the result of the Stats query. Count and AmountSum include items of all priorities
+// and pending items, dead-lettered items are counted apart.
+type QueueStats struct {
+	QueueName         string    `json:"QueueName"`
+	Count             int       `json:"Count"`
+	AmountSum         int       `json:"AmountSum"`
+	OldestCreatedTime time.Time `json:"OldestCreatedTime"`
+	DeadLetterCount   int       `json:"DeadLetterCount"`
+	PendingCount      int       `json:"PendingCount"`
+}
+
+// queueStats returns the queue counters
+// arg1 -> queueName string
+func queueStats(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	res, err := c.State().List([]string{listStatsKeyPrefix, queueName}, &ListStats{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list queue stats")
+	}
+	stats := QueueStats{QueueName: queueName}
+	for _, s := range res.([]interface{}) {
+		listStats := s.(ListStats)
+		if listStats.DeadLetter {
+			stats.DeadLetterCount += listStats.Count
+			continue
+		}
+		stats.Count += listStats.Count
+		stats.AmountSum += listStats.AmountSum
+	}
+	linkedCount := stats.Count
+	if linkedCount > 0 {
+		if stats.OldestCreatedTime, err = oldestCreatedTime(c, queueName); err != nil {
+			return nil, err
+		}
+	}
+
+	// pending items are not counted by list stats until linked
+	res, err = c.State().List([]string{pendingStatsKeyPrefix, queueName}, &pendingStats{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list pending stats")
+	}
+	for _, s := range res.([]interface{}) {
+		pending := s.(pendingStats)
+		if stats.PendingCount+linkedCount == 0 || pending.CreatedTime.Before(stats.OldestCreatedTime) {
+			stats.OldestCreatedTime = pending.CreatedTime
+		}
+		stats.PendingCount += pending.Count
+		stats.Count += pending.Count
+		stats.AmountSum += pending.AmountSum
+	}
+	return stats, nil
+}
+
+// oldestCreatedTime returns the creation time of the oldest not dead-lettered linked item.
+// Item keys end with ULID which starts with the creation time, so the oldest item is one of the first keys.
+// The time is not kept in ListStats: Pop of the oldest item would have to search for the next oldest one
+// with a range read of the queue items, so it would conflict with txs changing other bands. The query reads
+// the dead-lettered items created before the oldest queued one instead.
+func oldestCreatedTime(c router.Context, queueName string) (oldest time.Time, err error) {
+	iter, err := c.Stub().GetStateByPartialCompositeKey(queueItemKeyPrefix, []string{queueName})
+	if err != nil {
+		return oldest, errors.Wrap(err, "failed to read queue items")
+	}
+	defer iter.Close()
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return oldest, errors.Wrap(err, "failed to read queue item")
+		}
+		var item QueueItem
+		if err := json.Unmarshal(kv.Value, &item); err != nil {
+			return oldest, errors.Wrap(err, "failed to unmarshal queue item")
+		}
+		if !item.DeadLettered {
+			return item.CreatedTime, nil
+		}
+	}
+	return oldest, nil
+}
+
+// countItem adds the item to (n = 1) or removes it from (n = -1) the list stats.
+// Changes are collected during the tx and saved once by saveListStats.
+func countItem(c router.Context, l itemList, item QueueItem, n int) {
+	deltas, _ := c.Get(listStatsDeltaKey).([]*ListStats)
+	var delta *ListStats
+	for _, d := range deltas {
+		if d.list() == l {
+			delta = d
+		}
+	}
+	if delta == nil {
+		delta = &ListStats{QueueName: l.QueueName, Priority: l.Priority, DeadLetter: l.DeadLetter}
+		c.Set(listStatsDeltaKey, append(deltas, delta))
+	}
+	delta.Count += n
+	delta.AmountSum += n * item.Amount
+}
+
+// countPending adds the pending item to (n = 1) or removes it from (n = -1) the pending stats of its push tx.
+// Changes are collected during the tx and saved once by saveListStats.
+func countPending(c router.Context, item QueueItem, n int) {
+	deltas, _ := c.Get(pendingStatsDeltaKey).([]*pendingStats)
+	id := txItemID(item.ID)
+	var delta *pendingStats
+	for _, d := range deltas {
+		if d.QueueName == item.QueueName && d.TxItemID == id {
+			delta = d
+		}
+	}
+	if delta == nil {
+		delta = &pendingStats{QueueName: item.QueueName, TxItemID: id, CreatedTime: item.CreatedTime}
+		c.Set(pendingStatsDeltaKey, append(deltas, delta))
+	}
+	delta.Count += n
+	delta.AmountSum += n * item.Amount
+}
+
+// saveListStats is a router middleware applies stats changes collected by the successful tx,
+// stats of an emptied list or push tx are deleted
+func saveListStats(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		res, err := next(c)
+		if err != nil {
+			return res, err
+		}
+		if err := savePendingStats(c); err != nil {
+			return nil, err
+		}
+		deltas, _ := c.Get(listStatsDeltaKey).([]*ListStats)
+		for _, delta := range deltas {
+			if delta.Count == 0 && delta.AmountSum == 0 {
+				continue
+			}
+			empty := ListStats{QueueName: delta.QueueName, Priority: delta.Priority, DeadLetter: delta.DeadLetter}
+			cur, err := c.State().Get(delta, &ListStats{}, empty)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read list stats")
+			}
+			stats := cur.(ListStats)
+			stats.Count += delta.Count
+			stats.AmountSum += delta.AmountSum
+			if stats.Count == 0 {
+				err = deleteState(c, stats)
+			} else {
+				err = putState(c, stats)
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to save list stats")
+			}
+		}
+		return res, nil
+	}
+}
+
+// savePendingStats applies pending stats changes collected by the tx
+func savePendingStats(c router.Context) error {
+	deltas, _ := c.Get(pendingStatsDeltaKey).([]*pendingStats)
+	for _, delta := range deltas {
+		if delta.Count == 0 && delta.AmountSum == 0 {
+			continue
+		}
+		empty := pendingStats{QueueName: delta.QueueName, TxItemID: delta.TxItemID, CreatedTime: delta.CreatedTime}
+		cur, err := c.State().Get(delta, &pendingStats{}, empty)
+		if err != nil {
+			return errors.Wrap(err, "failed to read pending stats")
+		}
+		stats := cur.(pendingStats)
+		stats.Count += delta.Count
+		stats.AmountSum += delta.AmountSum
+		// items pushed before the pending stats were kept are not counted, don't keep negative counters
+		if stats.Count <= 0 {
+			err = deleteState(c, stats)
+		} else {
+			err = putState(c, stats)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to save pending stats")
+		}
+	}
+	return nil
+}
+
+// deleteListStats deletes stats of the list, used when all items of the list are deleted
+func deleteListStats(c router.Context, l itemList) error {
+	return deleteState(c, ListStats{QueueName: l.QueueName, Priority: l.Priority, DeadLetter: l.DeadLetter})
+}
@@ -1,12 +1,27 @@
 package hlfq_test
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/peer"
+	"github.com/oklog/ulid/v2"
 	hlfq "github.com/r3code/hlf-queue-example"
+	"github.com/s7techlab/cckit/convert"
+	"github.com/s7techlab/cckit/extensions/encryption"
+	"github.com/s7techlab/cckit/extensions/owner"
+	"github.com/s7techlab/cckit/identity"
 	"github.com/s7techlab/cckit/identity/testdata"
+	"github.com/s7techlab/cckit/router"
 	testcc "github.com/s7techlab/cckit/testing"
 	expectcc "github.com/s7techlab/cckit/testing/expect"
 
@@ -22,8 +37,262 @@ func TestHLFQueue(t *testing.T) {
 var (
 	Authority = testdata.Certificates[0].MustIdentity("SOME_MSP")
 	Someone   = testdata.Certificates[1].MustIdentity("SOME_MSP")
+	OtherOrg  = testdata.Certificates[2].MustIdentity("OTHER_MSP")
 )
 
+const defaultQueue = hlfq.DefaultQueueName
+
+// invokeAt invokes chaincode method with the specified tx ID and tx timestamp,
+// MockStub.Invoke generates random tx ID and takes the wall clock time instead
+func invokeAt(stub *testcc.MockStub, cc shim.Chaincode, txID string, txTime time.Time,
+	funcName string, iargs ...interface{}) peer.Response {
+	fargs, err := convert.ArgsToBytes(iargs...)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	stub.SetArgs(append([][]byte{[]byte(funcName)}, fargs...))
+	stub.MockTransactionStart(txID)
+	stub.TxTimestamp = testcc.MustProtoTimestamp(txTime)
+	res := cc.Invoke(stub)
+	stub.MockTransactionEnd(txID)
+	return res
+}
+
+// putState writes the value to the mock state bypassing the chaincode, nil value deletes the state.
+// Used to break the queue links.
+func putState(stub *testcc.MockStub, key []string, value interface{}) {
+	compositeKey, err := stub.CreateCompositeKey(key[0], key[1:])
+	Expect(err).NotTo(HaveOccurred())
+	stub.MockTransactionStart("put_state")
+	defer stub.MockTransactionEnd("put_state")
+	if value == nil {
+		Expect(stub.DelState(compositeKey)).To(Succeed())
+		return
+	}
+	bb, err := convert.ToBytes(value)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(stub.PutState(compositeKey, bb)).To(Succeed())
+}
+
+// faultyStub buffers state writes of the tx like a peer does and fails the Nth PutState,
+// buffered writes get to the mock state only if the tx succeeds. Like on a peer, the tx doesn't read
+// its own writes: GetState and key ranges return the state committed before the tx.
+type faultyStub struct {
+	*testcc.MockStub
+	failPutAt int
+	puts      int
+	writes    map[string][]byte // nil value is a deleted state
+}
+
+func (s *faultyStub) PutState(key string, value []byte) error {
+	s.puts++
+	if s.puts == s.failPutAt {
+		return errors.New("injected put failure")
+	}
+	s.writes[key] = value
+	return nil
+}
+
+func (s *faultyStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+// invokeFaulty invokes chaincode method failing the Nth PutState (0 - never fail),
+// returns the response and the number of PutState calls
+func invokeFaulty(stub *testcc.MockStub, cc shim.Chaincode, failPutAt int,
+	funcName string, iargs ...interface{}) (peer.Response, int) {
+	return invokeBuffered(stub, cc, "faulty", time.Time{}, failPutAt, funcName, iargs...)
+}
+
+// invokePeer invokes chaincode method with the specified tx ID and tx timestamp like an endorsing peer does:
+// the tx doesn't read its own writes, the writes get to the mock state only if the tx succeeds
+func invokePeer(stub *testcc.MockStub, cc shim.Chaincode, txID string, txTime time.Time,
+	funcName string, iargs ...interface{}) peer.Response {
+	res, _ := invokeBuffered(stub, cc, txID, txTime, 0, funcName, iargs...)
+	return res
+}
+
+// invokeBuffered invokes chaincode method through faultyStub, zero txTime keeps the wall clock time
+func invokeBuffered(stub *testcc.MockStub, cc shim.Chaincode, txID string, txTime time.Time, failPutAt int,
+	funcName string, iargs ...interface{}) (peer.Response, int) {
+	fargs, err := convert.ArgsToBytes(iargs...)
+	Expect(err).NotTo(HaveOccurred())
+	stub.SetArgs(append([][]byte{[]byte(funcName)}, fargs...))
+	fs := &faultyStub{MockStub: stub, failPutAt: failPutAt, writes: map[string][]byte{}}
+
+	stub.MockTransactionStart(txID)
+	if !txTime.IsZero() {
+		stub.TxTimestamp = testcc.MustProtoTimestamp(txTime)
+	}
+	defer stub.MockTransactionEnd(txID)
+	res := cc.Invoke(fs)
+	if res.Status == shim.OK {
+		for key, value := range fs.writes {
+			if value == nil {
+				Expect(stub.DelState(key)).To(Succeed())
+			} else {
+				Expect(stub.PutState(key, value)).To(Succeed())
+			}
+		}
+	}
+	return res, fs.puts
+}
+
+// historyStub keeps versions of every key like the peer history database does,
+// MockStub doesn't implement GetHistoryForKey. Only the last write of a key in a tx makes a version.
+type historyStub struct {
+	*testcc.MockStub
+	history map[string][]*queryresult.KeyModification
+}
+
+func newHistoryStub(name string, cc shim.Chaincode) *historyStub {
+	return &historyStub{MockStub: testcc.NewMockStub(name, cc), history: map[string][]*queryresult.KeyModification{}}
+}
+
+func (s *historyStub) addVersion(key string, value []byte, isDelete bool) {
+	mod := &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: s.TxTimestamp, IsDelete: isDelete}
+	versions := s.history[key]
+	if n := len(versions); n > 0 && versions[n-1].TxId == s.TxID {
+		versions[n-1] = mod
+		return
+	}
+	s.history[key] = append(versions, mod)
+}
+
+func (s *historyStub) PutState(key string, value []byte) error {
+	s.addVersion(key, value, false)
+	return s.MockStub.PutState(key, value)
+}
+
+func (s *historyStub) DelState(key string) error {
+	s.addVersion(key, nil, true)
+	return s.MockStub.DelState(key)
+}
+
+func (s *historyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{versions: s.history[key]}, nil
+}
+
+// invokeAt invokes chaincode method through the history stub with the specified tx ID and tx timestamp
+func (s *historyStub) invokeAt(cc shim.Chaincode, txID string, txTime time.Time,
+	funcName string, iargs ...interface{}) peer.Response {
+	fargs, err := convert.ArgsToBytes(iargs...)
+	Expect(err).NotTo(HaveOccurred())
+	s.SetArgs(append([][]byte{[]byte(funcName)}, fargs...))
+	s.MockTransactionStart(txID)
+	s.TxTimestamp = testcc.MustProtoTimestamp(txTime)
+	defer s.MockTransactionEnd(txID)
+	return cc.Invoke(s)
+}
+
+type historyIterator struct {
+	versions []*queryresult.KeyModification
+}
+
+func (i *historyIterator) HasNext() bool {
+	return len(i.versions) > 0
+}
+
+func (i *historyIterator) Next() (*queryresult.KeyModification, error) {
+	next := i.versions[0]
+	i.versions = i.versions[1:]
+	return next, nil
+}
+
+func (i *historyIterator) Close() error {
+	return nil
+}
+
+// blockStub simulates a tx like an endorsing peer does: writes are buffered and not seen by the tx itself,
+// keys read from the state and key ranges scanned are recorded for the MVCC validation
+type blockStub struct {
+	*testcc.MockStub
+	reads  map[string]bool
+	ranges []string          // prefixes of scanned composite keys
+	writes map[string][]byte // nil value is a deleted state
+}
+
+func (s *blockStub) GetState(key string) ([]byte, error) {
+	s.reads[key] = true
+	return s.MockStub.GetState(key)
+}
+
+func (s *blockStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	s.ranges = append(s.ranges, prefix)
+	return s.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+}
+
+func (s *blockStub) PutState(key string, value []byte) error {
+	s.writes[key] = value
+	return nil
+}
+
+func (s *blockStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+// blockTx is a tx of the simulated block
+type blockTx struct {
+	time     time.Time
+	funcName string
+	args     []interface{}
+}
+
+// commitBlock endorses all txs of the block against the same state, then validates them in the block order:
+// a tx is invalid if a key it read or a key range it scanned was written by a valid tx before it.
+// Writes of valid txs are applied to the mock state, returns validity of txs.
+func commitBlock(stub *testcc.MockStub, cc shim.Chaincode, txs ...blockTx) []bool {
+	simulated := make([]*blockStub, len(txs))
+	for i, tx := range txs {
+		fargs, err := convert.ArgsToBytes(tx.args...)
+		Expect(err).NotTo(HaveOccurred())
+		stub.SetArgs(append([][]byte{[]byte(tx.funcName)}, fargs...))
+		bs := &blockStub{MockStub: stub, reads: map[string]bool{}, writes: map[string][]byte{}}
+		txID := fmt.Sprintf("block_tx%d", tx.time.UnixNano())
+		stub.MockTransactionStart(txID)
+		stub.TxTimestamp = testcc.MustProtoTimestamp(tx.time)
+		expectcc.ResponseOk(cc.Invoke(bs))
+		stub.MockTransactionEnd(txID)
+		simulated[i] = bs
+	}
+
+	valid := make([]bool, len(txs))
+	written := map[string]bool{}
+	stub.MockTransactionStart("commit_block")
+	defer stub.MockTransactionEnd("commit_block")
+	for i, bs := range simulated {
+		valid[i] = true
+		for key := range written {
+			if bs.reads[key] {
+				valid[i] = false
+			}
+			for _, prefix := range bs.ranges {
+				if strings.HasPrefix(key, prefix) {
+					valid[i] = false
+				}
+			}
+		}
+		if !valid[i] {
+			continue
+		}
+		for key, value := range bs.writes {
+			written[key] = true
+			if value == nil {
+				Expect(stub.DelState(key)).To(Succeed())
+			} else {
+				Expect(stub.PutState(key, value)).To(Succeed())
+			}
+		}
+	}
+	return valid
+}
+
 var _ = Describe("HLFQueue", func() {
 
 	//Create chaincode mock
@@ -39,9 +308,9 @@ var _ = Describe("HLFQueue", func() {
 		It("Allows to push an item to the queue", func() {
 			testData := hlfq.ExampleItems[0]
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", testData))
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, testData))
 			// get list and check it has one expected element
-			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(items).To(HaveLen(1))
 			Expect(items[0].From).To(Equal(testData.From))
 			Expect(items[0].To).To(Equal(testData.To))
@@ -50,13 +319,13 @@ var _ = Describe("HLFQueue", func() {
 
 		It("Allows to pop an item from the queue", func() {
 			//invoke chaincode method from non authority actor
-			headitem := expectcc.PayloadIs(ccMockGlobal.Invoke("Pop"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			headitem := expectcc.PayloadIs(ccMockGlobal.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
 			Expect(headitem.From).To(Equal(hlfq.ExampleItems[0].From))
 			Expect(headitem.To).To(Equal(hlfq.ExampleItems[0].To))
 			Expect(headitem.Amount).To(Equal(hlfq.ExampleItems[0].Amount))
 
 			// get list and check it has 0 items now
-			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(items).To(HaveLen(0))
 		})
 
@@ -64,45 +333,1556 @@ var _ = Describe("HLFQueue", func() {
 			//invoke chaincode method from non authority actor
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", hlfq.ExampleItems[0]))
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]))
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", hlfq.ExampleItems[1]))
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", hlfq.ExampleItems[2]))
-			headItem1 := expectcc.PayloadIs(ccMockGlobal.Invoke("Pop"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2]))
+			headItem1 := expectcc.PayloadIs(ccMockGlobal.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
 			Expect(headItem1.From).To(Equal(hlfq.ExampleItems[0].From))
 			Expect(headItem1.To).To(Equal(hlfq.ExampleItems[0].To))
 			Expect(headItem1.Amount).To(Equal(hlfq.ExampleItems[0].Amount))
 			//
-			headItem2 := expectcc.PayloadIs(ccMockGlobal.Invoke("Pop"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			headItem2 := expectcc.PayloadIs(ccMockGlobal.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
 			Expect(headItem2.From).To(Equal(hlfq.ExampleItems[1].From))
 			Expect(headItem2.To).To(Equal(hlfq.ExampleItems[1].To))
 			Expect(headItem2.Amount).To(Equal(hlfq.ExampleItems[1].Amount))
 			//
-			headItem3 := expectcc.PayloadIs(ccMockGlobal.Invoke("Pop"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			headItem3 := expectcc.PayloadIs(ccMockGlobal.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
 			Expect(headItem3.From).To(Equal(hlfq.ExampleItems[2].From))
 			Expect(headItem3.To).To(Equal(hlfq.ExampleItems[2].To))
 			Expect(headItem3.Amount).To(Equal(hlfq.ExampleItems[2].Amount))
 
 			// get list and check it has 0 items now
-			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(items).To(HaveLen(0))
 		})
 
 	})
 
+	Describe("Remove", func() {
+		var (
+			ccMock *testcc.MockStub
+			items  []hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_remove", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			for _, spec := range hlfq.ExampleItems[0:3] {
+				expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, spec))
+			}
+			items = expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		})
+
+		listItems := func() []hlfq.QueueItem {
+			return expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		}
+
+		It("Removes middle, head and tail items", func() {
+			removed := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Remove", defaultQueue, items[1].ID.String()), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(removed.ID).To(Equal(items[1].ID))
+			Expect(removed.Amount).To(Equal(items[1].Amount))
+			remaining := listItems()
+			Expect(remaining).To(HaveLen(2))
+			Expect(remaining[0].ID).To(Equal(items[0].ID))
+			Expect(remaining[1].ID).To(Equal(items[2].ID))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, items[0].ID.String()))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, items[2].ID.String()))
+			Expect(listItems()).To(BeEmpty())
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Pop", defaultQueue), hlfq.ErrEmptyQueue)
+
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			Expect(report.ItemCount).To(Equal(0))
+		})
+
+		It("Keeps the queue working after removal", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, items[2].ID.String()))
+			pushed := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			remaining := listItems()
+			Expect(remaining).To(HaveLen(3))
+			Expect(remaining[2].ID).To(Equal(pushed.ID))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats).Count).
+				To(Equal(3))
+		})
+
+		It("Fails to remove a missing item", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, items[0].ID.String()))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Remove", defaultQueue, items[0].ID.String()), "failed to cut item")
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Remove", defaultQueue, "not-an-id"), "failed to cut item")
+		})
+	})
+
+	Describe("Batch Push and Pop", func() {
+		var (
+			cc       *router.Chaincode
+			ccMock   *testcc.MockStub
+			existing hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_batch", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			existing = expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+		})
+
+		It("Pushes all items in one tx in the order of specs", func() {
+			specs := []hlfq.QueueItemSpec{hlfq.ExampleItems[0], hlfq.ExampleItems[1], hlfq.ExampleItems[2]}
+			specs[1].Priority = 5
+			pushed := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("PushBatch", defaultQueue, specs), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(pushed).To(HaveLen(3))
+			for i := range specs {
+				Expect(pushed[i].Amount).To(Equal(specs[i].Amount))
+				if i > 0 {
+					Expect(pushed[i-1].ID.Compare(pushed[i].ID)).To(Equal(-1))
+				}
+			}
+
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(4))
+			for i, expected := range []hlfq.QueueItem{pushed[1], existing, pushed[0], pushed[2]} {
+				Expect(items[i].ID).To(Equal(expected.ID))
+			}
+			// pushed items are pending, they are linked by the next Pop or Compact
+			Expect(items[2]).To(Equal(pushed[0]))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+		})
+
+		It("Writes only the pushed items and the counters of the tx", func() {
+			// no tail item, pointer or list stats writes
+			res, puts := invokeFaulty(ccMock.From(Authority), cc, 0, "PushBatch", defaultQueue, hlfq.ExampleItems[0:3])
+			expectcc.ResponseOk(res)
+			Expect(puts).To(Equal(4))
+		})
+
+		It("Pushes nothing if any spec is invalid", func() {
+			specs := []hlfq.QueueItemSpec{hlfq.ExampleItems[0], {Priority: hlfq.MaxPriority + 1}}
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PushBatch", defaultQueue, specs), "invalid item #1")
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PushBatch", defaultQueue, []hlfq.QueueItemSpec{}), "Batch size must be")
+			Expect(expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{})).
+				To(HaveLen(1))
+		})
+
+		It("Pops up to n items in the Pop order", func() {
+			pushed := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("PushBatch", defaultQueue, hlfq.ExampleItems[0:2]), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("PopN", defaultQueue, 2), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(popped).To(HaveLen(2))
+			Expect(popped[0].ID).To(Equal(existing.ID))
+			Expect(popped[1].ID).To(Equal(pushed[0].ID))
+
+			popped = expectcc.PayloadIs(ccMock.From(Authority).Invoke("PopN", defaultQueue, 5), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(popped).To(HaveLen(1))
+			Expect(popped[0].ID).To(Equal(pushed[1].ID))
+
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PopN", defaultQueue, 5), hlfq.ErrEmptyQueue)
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PopN", defaultQueue, 0), "Pop count must be")
+		})
+
+		It("Pops distinct items when the tx doesn't read its own writes", func() {
+			specs := []hlfq.QueueItemSpec{hlfq.ExampleItems[0], hlfq.ExampleItems[1], hlfq.ExampleItems[2]}
+			specs[1].NotBefore = time.Now().Add(time.Hour)
+			pushed := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("PushBatch", defaultQueue, specs), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+
+			popped := expectcc.PayloadIs(invokePeer(ccMock.From(Authority), cc, "popN", time.Now(), "PopN", defaultQueue, 3),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(popped).To(HaveLen(3))
+			for i, expected := range []hlfq.QueueItem{existing, pushed[0], pushed[2]} {
+				Expect(popped[i].ID).To(Equal(expected.ID))
+			}
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].ID).To(Equal(pushed[1].ID))
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+		})
+	})
+
+	Describe("Concurrent Push", func() {
+		var (
+			cc     *router.Chaincode
+			ccMock *testcc.MockStub
+			txTime = time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_concurrent", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		push := func(i int) blockTx {
+			// txs of one block are endorsed in the same millisecond
+			return blockTx{time: txTime.Add(time.Duration(i) * time.Microsecond),
+				funcName: "Push", args: []interface{}{defaultQueue, hlfq.ExampleItems[i%len(hlfq.ExampleItems)]}}
+		}
+		pop := func(i int) blockTx {
+			return blockTx{time: txTime.Add(time.Second + time.Duration(i)*time.Microsecond),
+				funcName: "Pop", args: []interface{}{defaultQueue}}
+		}
+		listItems := func() []hlfq.QueueItem {
+			return expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		}
+
+		It("Commits all pushes of one block in the tx time order", func() {
+			Expect(commitBlock(ccMock.From(Authority), cc, push(0), push(1), push(2), push(3), push(4))).
+				To(Equal([]bool{true, true, true, true, true}))
+			Expect(commitBlock(ccMock.From(Authority), cc, push(5), push(6))).To(Equal([]bool{true, true}))
+
+			items := listItems()
+			Expect(items).To(HaveLen(7))
+			for i, item := range items {
+				Expect(item.CreatedTime).To(Equal(push(i).time))
+			}
+			stats := expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats)
+			Expect(stats.PendingCount).To(Equal(7))
+
+			linked := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Compact", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(linked).To(HaveLen(7))
+			for i, item := range listItems() {
+				Expect(item.ID).To(Equal(items[i].ID))
+			}
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			Expect(report.LinkedCount).To(Equal(7))
+			Expect(report.PendingCount).To(Equal(0))
+		})
+
+		It("Links pending items on Pop", func() {
+			Expect(commitBlock(ccMock.From(Authority), cc, push(0), push(1), push(2))).To(Equal([]bool{true, true, true}))
+			items := listItems()
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(items[0].ID))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport).
+				LinkedCount).To(Equal(2))
+		})
+
+		It("Invalidates a tx changing the lists after a push or a Pop of the same block", func() {
+			Expect(commitBlock(ccMock.From(Authority), cc, push(0), push(1))).To(Equal([]bool{true, true}))
+
+			// Pop links pending items, so it conflicts with another Pop and reads the pushed items range
+			Expect(commitBlock(ccMock.From(Authority), cc, pop(0), pop(1))).To(Equal([]bool{true, false}))
+			Expect(commitBlock(ccMock.From(Authority), cc, push(2), pop(2))).To(Equal([]bool{true, false}))
+			Expect(commitBlock(ccMock.From(Authority), cc, pop(3), push(3))).To(Equal([]bool{true, true}))
+
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			Expect(report.LinkedCount + report.PendingCount).To(Equal(2))
+		})
+
+		It("Reads pending items linked by the same tx like on a peer", func() {
+			at := func(i int) time.Time { return txTime.Add(time.Duration(i) * time.Second) }
+			peerInvoke := func(i int, funcName string, args ...interface{}) peer.Response {
+				return invokePeer(ccMock.From(Authority), cc, fmt.Sprintf("peer%d", i), at(i), funcName,
+					append([]interface{}{defaultQueue}, args...)...)
+			}
+			pushed := expectcc.PayloadIs(peerInvoke(0, "Push", hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			popped := expectcc.PayloadIs(peerInvoke(1, "Pop"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(pushed.ID))
+
+			// Compact links the first item, Pop links the second one after it
+			first := expectcc.PayloadIs(peerInvoke(2, "Push", hlfq.ExampleItems[1]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(peerInvoke(3, "Compact"))
+			second := expectcc.PayloadIs(peerInvoke(4, "Push", hlfq.ExampleItems[2]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			popped = expectcc.PayloadIs(peerInvoke(5, "Pop"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(first.ID))
+
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			items := listItems()
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].ID).To(Equal(second.ID))
+		})
+
+		It("Links at most MaxBatchSize pending items with the lowest IDs in one tx", func() {
+			specs := make([]hlfq.QueueItemSpec, hlfq.MaxBatchSize)
+			for i := range specs {
+				specs[i] = hlfq.ExampleItems[i%len(hlfq.ExampleItems)]
+			}
+			// the ID of the next millisecond is greater than the IDs of the batch
+			next := push(1)
+			next.time = txTime.Add(time.Millisecond)
+			Expect(commitBlock(ccMock.From(Authority), cc, next,
+				blockTx{time: txTime, funcName: "PushBatch", args: []interface{}{defaultQueue, specs}})).
+				To(Equal([]bool{true, true}))
+
+			linked := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Compact", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(linked).To(HaveLen(hlfq.MaxBatchSize))
+			for _, item := range linked {
+				Expect(item.CreatedTime).To(Equal(txTime))
+			}
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			Expect(report.PendingCount).To(Equal(1))
+
+			// the rest is linked by the next tx
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(linked[0].ID))
+			report = expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			Expect(report.LinkedCount).To(Equal(hlfq.MaxBatchSize))
+			Expect(report.PendingCount).To(Equal(0))
+			items := listItems()
+			Expect(items[len(items)-1].CreatedTime).To(Equal(next.time))
+		})
+
+		It("Links pending items in the client timestamp order, not in the commit order", func() {
+			Expect(commitBlock(ccMock.From(Authority), cc, push(2), push(0))).To(Equal([]bool{true, true}))
+			Expect(commitBlock(ccMock.From(Authority), cc, push(1))).To(Equal([]bool{true}))
+
+			linked := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Compact", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(linked).To(HaveLen(3))
+			for i, item := range linked {
+				Expect(item.CreatedTime).To(Equal(push(i).time))
+			}
+			// an item committed after the linking with an earlier timestamp is linked after the tail
+			late := push(3)
+			late.time = txTime.Add(-time.Microsecond)
+			Expect(commitBlock(ccMock.From(Authority), cc, late)).To(Equal([]bool{true}))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			items := listItems()
+			Expect(items).To(HaveLen(4))
+			Expect(items[3].CreatedTime).To(Equal(late.time))
+		})
+	})
+
+	Describe("Deque", func() {
+		var (
+			ccMock *testcc.MockStub
+			items  []hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_deque", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			items = make([]hlfq.QueueItem, 2)
+			for i := range items {
+				items[i] = expectcc.PayloadIs(
+					ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[i]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			}
+		})
+
+		listIDs := func() []string {
+			var ids []string
+			for _, item := range expectcc.PayloadIs(
+				ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem) {
+				ids = append(ids, item.ID.String())
+			}
+			return ids
+		}
+
+		It("Pushes an item before the head of its priority", func() {
+			front := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("PushFront", defaultQueue, hlfq.ExampleItems[2]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			urgent := hlfq.ExampleItems[3]
+			urgent.Priority = 5
+			high := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue, urgent), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(listIDs()).To(Equal([]string{
+				high.ID.String(), front.ID.String(), items[0].ID.String(), items[1].ID.String()}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(front.ID))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport).OK).
+				To(BeTrue())
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats).Count).
+				To(Equal(2))
+		})
+
+		It("Pops the last available item", func() {
+			last := expectcc.PayloadIs(ccMock.From(Authority).Invoke("PopBack", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(last.ID).To(Equal(items[1].ID))
+			Expect(listIDs()).To(Equal([]string{items[0].ID.String()}))
+
+			// a reserved item is skipped
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2]))
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("Reserve", defaultQueue, 60))
+			last = expectcc.PayloadIs(ccMock.From(Authority).Invoke("PopBack", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(last.Amount).To(Equal(hlfq.ExampleItems[2].Amount))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PopBack", defaultQueue), hlfq.ErrNoReadyItems)
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, items[0].ID.String()))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PopBack", defaultQueue), hlfq.ErrEmptyQueue)
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport).OK).
+				To(BeTrue())
+		})
+	})
+
+	Describe("Peek", func() {
+		var ccMock *testcc.MockStub
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_peek", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		It("Returns the same error as Pop on an empty queue", func() {
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Pop", defaultQueue), hlfq.ErrEmptyQueue)
+			expectcc.ResponseError(ccMock.Invoke("Peek", defaultQueue), hlfq.ErrEmptyQueue)
+			expectcc.ResponseError(ccMock.Invoke("PeekTail", defaultQueue), hlfq.ErrEmptyQueue)
+			expectcc.ResponseError(ccMock.Invoke("PeekN", defaultQueue, 2), hlfq.ErrEmptyQueue)
+
+			scheduled := hlfq.ExampleItems[0]
+			scheduled.NotBefore = time.Now().Add(time.Hour)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, scheduled))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Pop", defaultQueue), hlfq.ErrNoReadyItems)
+			expectcc.ResponseError(ccMock.Invoke("Peek", defaultQueue), hlfq.ErrNoReadyItems)
+		})
+
+		It("Shows items in the Pop order without consuming them", func() {
+			pushed := make([]hlfq.QueueItem, 3)
+			for i, priority := range []int{0, 5, 0} {
+				spec := hlfq.ExampleItems[i]
+				spec.Priority = priority
+				pushed[i] = expectcc.PayloadIs(
+					ccMock.From(Authority).Invoke("Push", defaultQueue, spec), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			}
+
+			head := expectcc.PayloadIs(ccMock.Invoke("Peek", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(head.ID).To(Equal(pushed[1].ID))
+			tail := expectcc.PayloadIs(ccMock.Invoke("PeekTail", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(tail.ID).To(Equal(pushed[2].ID))
+
+			first := expectcc.PayloadIs(ccMock.Invoke("PeekN", defaultQueue, 2), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(first).To(HaveLen(2))
+			Expect(first[0].ID).To(Equal(pushed[1].ID))
+			Expect(first[1].ID).To(Equal(pushed[0].ID))
+			all := expectcc.PayloadIs(ccMock.Invoke("PeekN", defaultQueue, 10), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(all).To(HaveLen(3))
+			expectcc.ResponseError(ccMock.Invoke("PeekN", defaultQueue, 0), "Peek count must be")
+
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped).To(Equal(head))
+		})
+	})
+
+	Describe("Named queues", func() {
+
+		It("Creates the default queue at init", func() {
+			ccMock := testcc.NewMockStub("hlfq_queues", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			queues := expectcc.PayloadIs(ccMock.Invoke("ListQueues"), &[]hlfq.Queue{}).([]hlfq.Queue)
+			Expect(queues).To(HaveLen(1))
+			Expect(queues[0].Name).To(Equal(hlfq.DefaultQueueName))
+		})
+
+		It("Keeps items of different queues apart", func() {
+			ccMock := testcc.NewMockStub("hlfq_queues", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("CreateQueue", "q1"))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("CreateQueue", "q2"))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", "q1", hlfq.ExampleItems[0]))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", "q2", hlfq.ExampleItems[1]))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", "q2", hlfq.ExampleItems[2]))
+
+			items1 := expectcc.PayloadIs(ccMock.Invoke("ListItems", "q1"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items1).To(HaveLen(1))
+			Expect(items1[0].QueueName).To(Equal("q1"))
+			Expect(items1[0].Amount).To(Equal(hlfq.ExampleItems[0].Amount))
+
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", "q2"), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.Amount).To(Equal(hlfq.ExampleItems[1].Amount))
+
+			// an item can not be addressed through another queue
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("AttachData", "q2", items1[0].ID.String(), []byte("data")))
+
+			queues := expectcc.PayloadIs(ccMock.Invoke("ListQueues"), &[]hlfq.Queue{}).([]hlfq.Queue)
+			Expect(queues).To(HaveLen(3))
+		})
+
+		It("Deletes a queue with all its items", func() {
+			ccMock := testcc.NewMockStub("hlfq_queues", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("CreateQueue", "q1"))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", "q1", hlfq.ExampleItems[0]))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("DeleteQueue", "q1"))
+
+			expectcc.ResponseError(ccMock.Invoke("ListItems", "q1"), "Queue 'q1' not exists")
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(1))
+		})
+
+		It("Allows only the owner to manage queues", func() {
+			ccMock := testcc.NewMockStub("hlfq_queues", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("CreateQueue", "q1"), owner.ErrOwnerOnly)
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("DeleteQueue", defaultQueue), owner.ErrOwnerOnly)
+		})
+
+		It("Fails on unknown queue", func() {
+			ccMock := testcc.NewMockStub("hlfq_queues", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Push", "unknown", hlfq.ExampleItems[0]), "Queue 'unknown' not exists")
+		})
+
+		It("Moves items of the single queue version to the default queue on upgrade", func() {
+			ccMock := testcc.NewMockStub("hlfq_queues", hlfq.New())
+			// the ledger of the single queue version: item keys without the queue name, list a -> b -> c
+			// and an orphan item d
+			t := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+			ids := make([]ulid.ULID, 4)
+			for i := range ids {
+				ids[i] = ulid.MustNew(ulid.Timestamp(t.Add(time.Duration(i)*time.Second)), nil)
+			}
+			a, b, c, d := ids[0], ids[2], ids[3], ids[1]
+			oldKey := func(id ulid.ULID) []string { return []string{"queueItemKey", id.String()} }
+			empty := hlfq.EmptyItemPointerKey
+			for i, links := range [][]interface{}{{a, empty, oldKey(b)}, {b, oldKey(a), oldKey(c)},
+				{c, oldKey(b), empty}, {d, empty, empty}} {
+				id := links[0].(ulid.ULID)
+				putState(ccMock, oldKey(id), map[string]interface{}{"ID": id, "PrevKey": links[1], "NextKey": links[2],
+					"CreatedTime": ulid.Time(id.Time()).UTC(), "From": "A", "To": "B", "Amount": i + 1})
+			}
+			putState(ccMock, []string{"queuePointer", "HeadPointer"},
+				map[string]interface{}{"PointerName": "HeadPointer", "PointerKey": oldKey(a)})
+			putState(ccMock, []string{"queuePointer", "TailPointer"},
+				map[string]interface{}{"PointerName": "TailPointer", "PointerKey": oldKey(c)})
+
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(4))
+			for i, id := range []ulid.ULID{a, b, c, d} {
+				Expect(items[i].ID).To(Equal(id))
+				Expect(items[i].QueueName).To(Equal(defaultQueue))
+			}
+			Expect(items[3].Amount).To(Equal(4))
+			Expect(items[0].CreatedTime).To(Equal(t))
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			Expect(report.ItemCount).To(Equal(4))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats).Count).
+				To(Equal(4))
+			for _, key := range [][]string{oldKey(a), oldKey(d), {"queuePointer", "HeadPointer"}, {"queuePointer", "TailPointer"}} {
+				compositeKey, _ := ccMock.CreateCompositeKey(key[0], key[1:])
+				Expect(ccMock.State).NotTo(HaveKey(compositeKey))
+			}
+
+			// the next upgrade has nothing to migrate
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(a))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{})).To(HaveLen(3))
+		})
+	})
+
+	Describe("Priority queue", func() {
+
+		withPriority := func(spec hlfq.QueueItemSpec, priority int) hlfq.QueueItemSpec {
+			spec.Priority = priority
+			return spec
+		}
+
+		It("Pops the highest priority items first keeping FIFO inside a priority", func() {
+			ccMock := testcc.NewMockStub("hlfq_priority", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]))                  // Amount=1, P0
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, withPriority(hlfq.ExampleItems[1], 5))) // Amount=2, P5
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, withPriority(hlfq.ExampleItems[2], 9))) // Amount=3, P9
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, withPriority(hlfq.ExampleItems[3], 5))) // Amount=4, P5
+
+			expectedAmounts := []int{3, 2, 4, 1}
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(len(expectedAmounts)))
+			for i, amount := range expectedAmounts {
+				Expect(items[i].Amount).To(Equal(amount), "ListItems #%d", i)
+			}
+
+			for i, amount := range expectedAmounts {
+				popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+				Expect(popped.Amount).To(Equal(amount), "Pop #%d", i)
+			}
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Pop", defaultQueue), "Empty queue")
+		})
+
+		It("Selects items in the effective order", func() {
+			ccMock := testcc.NewMockStub("hlfq_priority", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))                  // Amount=2, P0
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, withPriority(hlfq.ExampleItems[2], 1))) // Amount=3, P1
+
+			filteredItems := expectcc.PayloadIs(
+				ccMock.Invoke("Select", defaultQueue, "{.Amount > 1}"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(filteredItems).To(HaveLen(2))
+			Expect(filteredItems[0].Amount).To(Equal(3))
+			Expect(filteredItems[1].Amount).To(Equal(2))
+		})
+
+		It("Moves an item into the priority of the target item", func() {
+			ccMock := testcc.NewMockStub("hlfq_priority", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, withPriority(hlfq.ExampleItems[0], 2))) // Amount=1, P2
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))                  // Amount=2, P0
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+
+			movedItem := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("MoveBefore", defaultQueue, items[1].ID.String(), items[0].ID.String()),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(movedItem.Priority).To(Equal(2))
+
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.Amount).To(Equal(2))
+		})
+
+		It("Rejects priority out of range", func() {
+			ccMock := testcc.NewMockStub("hlfq_priority", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			expectcc.ResponseError(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, withPriority(hlfq.ExampleItems[0], hlfq.MaxPriority+1)),
+				"Priority must be from")
+		})
+	})
+
+	Describe("Scheduled items", func() {
+
+		It("Does not pop items before their NotBefore time", func() {
+			cc := hlfq.New()
+			ccMock := testcc.NewMockStub("hlfq_scheduled", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+
+			txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+			scheduled := hlfq.ExampleItems[0] // Amount=1
+			scheduled.NotBefore = txTime.Add(time.Hour)
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "push1", txTime, "Push", defaultQueue, scheduled))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "push2", txTime, "Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
+
+			pending := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "list1", txTime, "ListScheduled", defaultQueue),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(pending).To(HaveLen(1))
+			Expect(pending[0].Amount).To(Equal(1))
+
+			// the scheduled head is skipped
+			popped := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "pop1", txTime, "Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.Amount).To(Equal(2))
+			expectcc.ResponseError(
+				invokeAt(ccMock.From(Authority), cc, "pop2", txTime.Add(time.Minute), "Pop", defaultQueue), "No ready items in queue")
+
+			popped = expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "pop3", txTime.Add(time.Hour), "Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.Amount).To(Equal(1))
+
+			pending = expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "list2", txTime.Add(time.Hour), "ListScheduled", defaultQueue),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(pending).To(HaveLen(0))
+			expectcc.ResponseError(
+				invokeAt(ccMock.From(Authority), cc, "pop4", txTime.Add(time.Hour), "Pop", defaultQueue), "Empty queue")
+		})
+	})
+
+	Describe("Reserve/Ack/Nack", func() {
+		txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+
+		It("Leases items to consumers until Ack or Nack", func() {
+			cc := hlfq.New()
+			ccMock := testcc.NewMockStub("hlfq_lease", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
+
+			reserved1 := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "reserve1", txTime, "Reserve", defaultQueue, 60),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(reserved1.Amount).To(Equal(1))
+			Expect(reserved1.LeaseOwner.MSPID).To(Equal(Authority.GetMSPIdentifier()))
+			Expect(reserved1.LeaseExpires).To(BeTemporally("==", txTime.Add(time.Minute)))
+
+			reserved2 := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Someone), cc, "reserve2", txTime, "Reserve", defaultQueue, 60),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(reserved2.Amount).To(Equal(2))
+
+			// leased items are not available to Pop, but still in the queue
+			expectcc.ResponseError(
+				invokeAt(ccMock.From(Authority), cc, "pop1", txTime, "Pop", defaultQueue), "No ready items in queue")
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(2))
+
+			// only the lease owner can Ack or Nack
+			expectcc.ResponseError(
+				invokeAt(ccMock.From(Someone), cc, "ack1", txTime, "Ack", defaultQueue, reserved1.ID.String()),
+				"Item is not reserved by the caller")
+			acked := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "ack2", txTime, "Ack", defaultQueue, reserved1.ID.String()),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(acked.ID).To(Equal(reserved1.ID))
+
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "nack1", txTime, "Nack", defaultQueue, reserved2.ID.String()))
+			popped := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "pop2", txTime, "Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(reserved2.ID))
+			expectcc.ResponseError(
+				invokeAt(ccMock.From(Authority), cc, "pop3", txTime, "Pop", defaultQueue), "Empty queue")
+		})
+
+		It("Makes an item available at its position when the lease expires", func() {
+			cc := hlfq.New()
+			ccMock := testcc.NewMockStub("hlfq_lease", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
+
+			reserved := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Authority), cc, "reserve1", txTime, "Reserve", defaultQueue, 10),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+
+			expired := txTime.Add(11 * time.Second)
+			expectcc.ResponseError(
+				invokeAt(ccMock.From(Authority), cc, "ack1", expired, "Ack", defaultQueue, reserved.ID.String()),
+				"Item is not reserved by the caller")
+			reservedAgain := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Someone), cc, "reserve2", expired, "Reserve", defaultQueue, 10),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(reservedAgain.ID).To(Equal(reserved.ID))
+			Expect(reservedAgain.LeaseOwner.Subject).To(Equal(Someone.GetSubject()))
+		})
+	})
+
+	Describe("Dead-letter list", func() {
+		txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+
+		var (
+			cc     *router.Chaincode
+			ccMock *testcc.MockStub
+			items  []hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_dlq", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 2))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
+			items = expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		})
+
+		It("Allows only the owner to set max delivery attempts", func() {
+			expectcc.ResponseError(
+				ccMock.From(Someone).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1), owner.ErrOwnerOnly)
+		})
+
+		It("Moves an item to the dead-letter list after max Nack-ed deliveries", func() {
+			for attempt := 1; attempt <= 2; attempt++ {
+				reserved := expectcc.PayloadIs(
+					invokeAt(ccMock.From(Someone), cc, fmt.Sprintf("reserve%d", attempt), txTime, "Reserve", defaultQueue, 10),
+					&hlfq.QueueItem{}).(hlfq.QueueItem)
+				Expect(reserved.ID).To(Equal(items[0].ID))
+				Expect(reserved.DeliveryAttempts).To(Equal(attempt))
+				expectcc.ResponseOk(
+					invokeAt(ccMock.From(Someone), cc, fmt.Sprintf("nack%d", attempt), txTime, "Nack", defaultQueue, reserved.ID.String()))
+			}
+
+			deadLetters := expectcc.PayloadIs(
+				ccMock.Invoke("ListDeadLetters", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].ID).To(Equal(items[0].ID))
+			Expect(deadLetters[0].DeadLettered).To(BeTrue())
+
+			liveItems := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(liveItems).To(HaveLen(1))
+			Expect(liveItems[0].ID).To(Equal(items[1].ID))
+
+			deadLetter := expectcc.PayloadIs(
+				ccMock.Invoke("GetDeadLetter", defaultQueue, items[0].ID.String()), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(deadLetter.Amount).To(Equal(items[0].Amount))
+			expectcc.ResponseError(ccMock.Invoke("GetDeadLetter", defaultQueue, items[1].ID.String()), "Item is not dead-lettered")
+		})
+
+		It("Moves an item to the dead-letter list when the last lease expires", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "reserve1", txTime, "Reserve", defaultQueue, 10))
+
+			reserved := expectcc.PayloadIs(
+				invokeAt(ccMock.From(Someone), cc, "reserve2", txTime.Add(time.Minute), "Reserve", defaultQueue, 10),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(reserved.ID).To(Equal(items[1].ID))
+			Expect(reserved.PrevKey).To(Equal(hlfq.EmptyItemPointerKey))
+
+			deadLetters := expectcc.PayloadIs(
+				ccMock.Invoke("ListDeadLetters", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].ID).To(Equal(items[0].ID))
+		})
+
+		It("Moves several exhausted items by one Pop when the tx doesn't read its own writes", func() {
+			scheduledSpec := hlfq.ExampleItems[2]
+			scheduledSpec.NotBefore = txTime.Add(time.Hour)
+			scheduled := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, scheduledSpec), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			exhausted := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			ready := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			for i, expected := range []hlfq.QueueItem{items[0], items[1], exhausted} {
+				reserved := expectcc.PayloadIs(
+					invokeAt(ccMock.From(Someone), cc, fmt.Sprintf("reserve%d", i), txTime, "Reserve", defaultQueue, 10),
+					&hlfq.QueueItem{}).(hlfq.QueueItem)
+				Expect(reserved.ID).To(Equal(expected.ID))
+			}
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "nack", txTime, "Nack", defaultQueue, items[0].ID.String()))
+
+			// the second item (the head) and the fourth one (between the scheduled and the ready ones) are dead-lettered
+			popped := expectcc.PayloadIs(
+				invokePeer(ccMock.From(Authority), cc, "pop", txTime.Add(time.Minute), "Pop", defaultQueue),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(ready.ID))
+
+			deadLetters := expectcc.PayloadIs(
+				ccMock.Invoke("ListDeadLetters", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(deadLetters).To(HaveLen(3))
+			for i, expected := range []hlfq.QueueItem{items[0], items[1], exhausted} {
+				Expect(deadLetters[i].ID).To(Equal(expected.ID))
+			}
+			liveItems := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(liveItems).To(HaveLen(1))
+			Expect(liveItems[0].ID).To(Equal(scheduled.ID))
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+		})
+
+		It("Requeues a dead-lettered item to the head or tail", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			for i := range items {
+				expectcc.ResponseOk(
+					invokeAt(ccMock.From(Someone), cc, fmt.Sprintf("reserve%d", i), txTime, "Reserve", defaultQueue, 10))
+				expectcc.ResponseOk(
+					invokeAt(ccMock.From(Someone), cc, fmt.Sprintf("nack%d", i), txTime, "Nack", defaultQueue, items[i].ID.String()))
+			}
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Pop", defaultQueue), "Empty queue")
+
+			expectcc.ResponseError(
+				ccMock.From(Authority).Invoke("RequeueDeadLetter", defaultQueue, items[0].ID.String(), "middle"), "Requeue position must be")
+			requeued := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("RequeueDeadLetter", defaultQueue, items[0].ID.String(), hlfq.RequeueToTail),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(requeued.DeadLettered).To(BeFalse())
+			Expect(requeued.DeliveryAttempts).To(Equal(0))
+			expectcc.ResponseOk(
+				ccMock.From(Authority).Invoke("RequeueDeadLetter", defaultQueue, items[1].ID.String(), hlfq.RequeueToHead))
+
+			liveItems := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(liveItems).To(HaveLen(2))
+			Expect(liveItems[0].ID).To(Equal(items[1].ID))
+			Expect(liveItems[1].ID).To(Equal(items[0].ID))
+			deadLetters := expectcc.PayloadIs(
+				ccMock.Invoke("ListDeadLetters", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(deadLetters).To(HaveLen(0))
+		})
+
+		It("Doesn't move items between the queue and the dead-letter list", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "reserve1", txTime, "Reserve", defaultQueue, 10))
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "nack1", txTime, "Nack", defaultQueue, items[0].ID.String()))
+
+			const errMsg = "Can not move an item between the queue and the dead-letter list"
+			dead, live := items[0].ID.String(), items[1].ID.String()
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("MoveAfter", defaultQueue, dead, live), errMsg)
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("MoveBefore", defaultQueue, dead, live), errMsg)
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("MoveAfter", defaultQueue, live, dead), errMsg)
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("MoveBefore", defaultQueue, live, dead), errMsg)
+
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.OK).To(BeTrue())
+			stats := expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats)
+			Expect(stats.Count).To(Equal(1))
+			Expect(stats.DeadLetterCount).To(Equal(1))
+		})
+
+		It("Purges dead-lettered items", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "reserve1", txTime, "Reserve", defaultQueue, 10))
+			expectcc.ResponseOk(
+				invokeAt(ccMock.From(Someone), cc, "nack1", txTime, "Nack", defaultQueue, items[0].ID.String()))
+
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("PurgeDeadLetters", defaultQueue), owner.ErrOwnerOnly)
+			purged := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("PurgeDeadLetters", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(purged).To(HaveLen(1))
+			Expect(purged[0].ID).To(Equal(items[0].ID))
+
+			deadLetters := expectcc.PayloadIs(
+				ccMock.Invoke("ListDeadLetters", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(deadLetters).To(HaveLen(0))
+			expectcc.ResponseError(ccMock.Invoke("GetDeadLetter", defaultQueue, items[0].ID.String()))
+		})
+	})
+
+	Describe("State errors", func() {
+		// setup makes a queue of items A, B, C with priority 0 and D with priority 5
+		setup := func() (*router.Chaincode, *testcc.MockStub, []hlfq.QueueItem) {
+			cc := hlfq.New()
+			ccMock := testcc.NewMockStub("hlfq_faults", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			for i, priority := range []int{0, 0, 0, 5} {
+				spec := hlfq.ExampleItems[i]
+				spec.Priority = priority
+				expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, spec))
+			}
+			items := expectcc.PayloadIs(
+				ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			return cc, ccMock, items // D, A, B, C
+		}
+
+		type operation struct {
+			method string
+			args   func(items []hlfq.QueueItem) []interface{}
+		}
+		operations := []operation{
+			{"Push", func([]hlfq.QueueItem) []interface{} { return []interface{}{hlfq.ExampleItems[0]} }},
+			{"Pop", func([]hlfq.QueueItem) []interface{} { return nil }},
+			{"PushBatch", func([]hlfq.QueueItem) []interface{} {
+				return []interface{}{hlfq.ExampleItems[0:3]}
+			}},
+			{"PopN", func([]hlfq.QueueItem) []interface{} { return []interface{}{3} }},
+			{"MoveAfter", func(items []hlfq.QueueItem) []interface{} {
+				return []interface{}{items[1].ID.String(), items[3].ID.String()} // A after C
+			}},
+			{"MoveAfter", func(items []hlfq.QueueItem) []interface{} {
+				return []interface{}{items[2].ID.String(), items[0].ID.String()} // B after D, to another band
+			}},
+			{"MoveBefore", func(items []hlfq.QueueItem) []interface{} {
+				return []interface{}{items[3].ID.String(), items[1].ID.String()} // C before A
+			}},
+		}
+
+		It("Fails the tx on any failed Put, so a broken queue is never committed", func() {
+			for _, op := range operations {
+				cc, ccMock, items := setup()
+				args := append([]interface{}{defaultQueue}, op.args(items)...)
+				res, puts := invokeFaulty(ccMock.From(Authority), cc, 0, op.method, args...)
+				expectcc.ResponseOk(res)
+				Expect(puts).To(BeNumerically(">", 0))
+
+				for failAt := 1; failAt <= puts; failAt++ {
+					cc, ccMock, items := setup()
+					args := append([]interface{}{defaultQueue}, op.args(items)...)
+					res, _ := invokeFaulty(ccMock.From(Authority), cc, failAt, op.method, args...)
+					Expect(res.Status).To(BeNumerically("==", shim.ERROR), "%s fails on put #%d", op.method, failAt)
+					Expect(res.Message).To(MatchRegexp(`state (put|insert) \[`))
+					Expect(res.Message).To(ContainSubstring("injected put failure"))
+
+					report := expectcc.PayloadIs(
+						ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+					Expect(report.OK).To(BeTrue())
+					Expect(expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{})).
+						To(Equal(items))
+				}
+			}
+		})
+	})
+
+	Describe("Archive", func() {
+		var (
+			cc     *router.Chaincode
+			ccMock *testcc.MockStub
+			items  []hlfq.QueueItem
+			day    = time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_archive", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			items = make([]hlfq.QueueItem, 3)
+			for i := range items {
+				items[i] = expectcc.PayloadIs(invokeAt(ccMock.From(Authority), cc, fmt.Sprintf("push%d", i), day.Add(time.Duration(i)*time.Second),
+					"Push", defaultQueue, hlfq.ExampleItems[i]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			}
+		})
+
+		listArchive := func(from, to time.Time) []hlfq.ArchivedItem {
+			return expectcc.PayloadIs(ccMock.Invoke("ListArchive", defaultQueue,
+				from.Format(time.RFC3339), to.Format(time.RFC3339)), &[]hlfq.ArchivedItem{}).([]hlfq.ArchivedItem)
+		}
+
+		It("Deletes popped items unless the archive mode is on", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			expectcc.ResponseError(ccMock.Invoke("GetArchived", defaultQueue, items[0].ID.String()),
+				"failed to read archived item")
+
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("SetArchiveMode", defaultQueue, true), owner.ErrOwnerOnly)
+			queue := expectcc.PayloadIs(ccMock.From(Authority).Invoke("SetArchiveMode", defaultQueue, true),
+				&hlfq.Queue{}).(hlfq.Queue)
+			Expect(queue.ArchivePopped).To(BeTrue())
+
+			expectcc.ResponseOk(invokeAt(ccMock.From(Someone), cc, "pop2", day.Add(time.Hour), "Pop", defaultQueue))
+			archived := expectcc.PayloadIs(ccMock.Invoke("GetArchived", defaultQueue, items[1].ID.String()),
+				&hlfq.ArchivedItem{}).(hlfq.ArchivedItem)
+			Expect(archived.Item.ID).To(Equal(items[1].ID))
+			Expect(archived.Item.Amount).To(Equal(items[1].Amount))
+			Expect(archived.PoppedTime).To(Equal(day.Add(time.Hour)))
+			Expect(archived.PoppedBy.MSPID).To(Equal("SOME_MSP"))
+			Expect(archived.TxID).To(Equal("pop2"))
+			Expect(archived.Method).To(Equal("Pop"))
+		})
+
+		It("Fails to pop to the archive without the tx creator", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetArchiveMode", defaultQueue, true))
+			expectcc.ResponseError(ccMock.Invoke("Pop", defaultQueue), "failed to delete popped item")
+		})
+
+		It("Lists archived items by the pop time range", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetArchiveMode", defaultQueue, true))
+			next := day.Add(24 * time.Hour)
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "pop1", day.Add(time.Hour), "Pop", defaultQueue))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "pop2", next, "PopBack", defaultQueue))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Someone), cc, "reserve", next, "Reserve", defaultQueue, 60))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Someone), cc, "ack", next.Add(time.Second),
+				"Ack", defaultQueue, items[1].ID.String()))
+
+			all := listArchive(day.Add(-time.Hour), next.Add(time.Hour))
+			Expect(all).To(HaveLen(3))
+			for i, id := range []string{items[0].ID.String(), items[2].ID.String(), items[1].ID.String()} {
+				Expect(all[i].Item.ID.String()).To(Equal(id))
+			}
+			Expect(all[2].Method).To(Equal("Ack"))
+
+			second := listArchive(next, next.Add(time.Second)) // to is exclusive
+			Expect(second).To(HaveLen(1))
+			Expect(second[0].Item.ID).To(Equal(items[2].ID))
+			Expect(listArchive(day, day.Add(time.Hour))).To(BeEmpty())
+
+			expectcc.ResponseError(ccMock.Invoke("ListArchive", defaultQueue, day.Format(time.RFC3339),
+				day.Add(40*24*time.Hour).Format(time.RFC3339)), "Archive time range must be")
+			expectcc.ResponseError(ccMock.Invoke("ListArchive", defaultQueue, "yesterday", day.Format(time.RFC3339)),
+				"invalid from time")
+		})
+
+		It("Requeues an archived item to the tail", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetArchiveMode", defaultQueue, true))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "pop1", day.Add(time.Hour), "Pop", defaultQueue))
+
+			requeued := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Requeue", defaultQueue, items[0].ID.String()),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(requeued.ID).To(Equal(items[0].ID))
+			listed := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(listed).To(HaveLen(3))
+			Expect(listed[2].ID).To(Equal(items[0].ID))
+
+			Expect(listArchive(day, day.Add(24*time.Hour))).To(BeEmpty())
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Requeue", defaultQueue, items[0].ID.String()),
+				"failed to read archived item")
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport).OK).
+				To(BeTrue())
+		})
+	})
+
+	Describe("Item history", func() {
+		var (
+			cc     *router.Chaincode
+			hStub  *historyStub
+			txTime = time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			hStub = newHistoryStub("hlfq_history", cc)
+			expectcc.ResponseOk(hStub.From(Authority).Init())
+			// invokeAt doesn't clear the creator
+			hStub.From(Authority)
+		})
+
+		It("Returns every committed version of the item", func() {
+			first := expectcc.PayloadIs(hStub.invokeAt(cc, "push1", txTime, "Push", defaultQueue, hlfq.ExampleItems[0]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			second := expectcc.PayloadIs(hStub.invokeAt(cc, "push2", txTime.Add(time.Second), "Push", defaultQueue,
+				hlfq.ExampleItems[1]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(hStub.invokeAt(cc, "attach", txTime.Add(2*time.Second),
+				"AttachData", defaultQueue, first.ID.String(), []byte("data")))
+			expectcc.ResponseOk(hStub.invokeAt(cc, "pop", txTime.Add(3*time.Second), "Pop", defaultQueue))
+
+			versions := expectcc.PayloadIs(hStub.invokeAt(cc, "history", txTime.Add(4*time.Second),
+				"ItemHistory", defaultQueue, first.ID.String()), &[]hlfq.ItemVersion{}).([]hlfq.ItemVersion)
+			Expect(versions).To(HaveLen(4))
+
+			// pushed as a pending record
+			Expect(versions[0].TxID).To(Equal("push1"))
+			Expect(versions[0].Timestamp).To(Equal(txTime))
+			Expect(versions[0].Pending).To(BeTrue())
+			Expect(versions[0].Item.ID).To(Equal(first.ID))
+
+			// linked and changed by AttachData
+			Expect(versions[1]).To(Equal(hlfq.ItemVersion{TxID: "attach", Timestamp: txTime.Add(2 * time.Second),
+				IsDelete: true, Pending: true}))
+			Expect(versions[2].TxID).To(Equal("attach"))
+			Expect(versions[2].Pending).To(BeFalse())
+			Expect(string(versions[2].Item.ExtraData)).To(Equal("data"))
+			Expect(versions[2].Item.NextKey).To(Equal([]string{"queueItemKey", defaultQueue, second.ID.String()}))
+
+			// popped
+			Expect(versions[3]).To(Equal(hlfq.ItemVersion{TxID: "pop", Timestamp: txTime.Add(3 * time.Second),
+				IsDelete: true}))
+		})
+
+		It("Returns no versions of an unknown item", func() {
+			Expect(expectcc.PayloadIs(hStub.invokeAt(cc, "history", txTime, "ItemHistory", defaultQueue,
+				"01E8ZQ3TF4M3V2JQ9F8R0Z9XYZ"), &[]hlfq.ItemVersion{})).To(BeEmpty())
+			expectcc.ResponseError(hStub.invokeAt(cc, "history", txTime, "ItemHistory", defaultQueue, "not-an-id"),
+				"invalid ULID")
+		})
+	})
+
+	Describe("Queue stats", func() {
+		var (
+			cc     *router.Chaincode
+			ccMock *testcc.MockStub
+			txTime = time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_stats", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		stats := func() hlfq.QueueStats {
+			return expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats)
+		}
+
+		It("Returns zero stats of the empty queue", func() {
+			Expect(stats()).To(Equal(hlfq.QueueStats{QueueName: defaultQueue}))
+		})
+
+		It("Counts items and Amount of all priorities on Push and Pop", func() {
+			for i, priority := range []int{0, 5, 0} {
+				spec := hlfq.ExampleItems[i] // Amount=1,2,3
+				spec.Priority = priority
+				expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, fmt.Sprintf("push%d", i), txTime.Add(time.Duration(i)*time.Second),
+					"Push", defaultQueue, spec))
+			}
+			Expect(stats()).To(Equal(hlfq.QueueStats{
+				QueueName: defaultQueue, Count: 3, AmountSum: 6, OldestCreatedTime: txTime, PendingCount: 3}))
+
+			// pops the priority 5 item (Amount=2), the oldest item stays
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			Expect(stats()).To(Equal(hlfq.QueueStats{
+				QueueName: defaultQueue, Count: 2, AmountSum: 4, OldestCreatedTime: txTime}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			Expect(stats()).To(Equal(hlfq.QueueStats{
+				QueueName: defaultQueue, Count: 1, AmountSum: 3, OldestCreatedTime: txTime.Add(2 * time.Second)}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			Expect(stats()).To(Equal(hlfq.QueueStats{QueueName: defaultQueue}))
+		})
+
+		It("Counts pending items by the counters written by Push", func() {
+			pendingStatsKeys := func() []string {
+				var keys []string
+				for key := range ccMock.State {
+					if strings.HasPrefix(key, "\x00pendingStatsKey\x00") {
+						keys = append(keys, key)
+					}
+				}
+				return keys
+			}
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "batch", txTime, "PushBatch", defaultQueue,
+				hlfq.ExampleItems[0:3])) // Amount=1,2,3
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "push", txTime.Add(time.Second), "Push", defaultQueue,
+				hlfq.ExampleItems[3])) // Amount=4
+			// one counter per push tx
+			Expect(pendingStatsKeys()).To(HaveLen(2))
+			Expect(stats()).To(Equal(hlfq.QueueStats{
+				QueueName: defaultQueue, Count: 4, AmountSum: 10, OldestCreatedTime: txTime, PendingCount: 4}))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport).OK).
+				To(BeTrue())
+
+			// linked items move to the list stats
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			Expect(pendingStatsKeys()).To(BeEmpty())
+			Expect(stats()).To(Equal(hlfq.QueueStats{
+				QueueName: defaultQueue, Count: 4, AmountSum: 10, OldestCreatedTime: txTime}))
+
+			// a lost counter is reported by Verify
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "push2", txTime.Add(2*time.Second), "Push", defaultQueue,
+				hlfq.ExampleItems[0]))
+			for _, key := range pendingStatsKeys() {
+				objectType, attrs, err := ccMock.SplitCompositeKey(key)
+				Expect(err).NotTo(HaveOccurred())
+				putState(ccMock, append([]string{objectType}, attrs...), nil)
+			}
+			report := expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+			Expect(report.Issues).To(Equal([]hlfq.IntegrityIssue{
+				{List: hlfq.PendingListName, Problem: hlfq.IssueStatsMismatch}}))
+		})
+
+		It("Keeps stats on Move, dead-lettering and queue deletion", func() {
+			low := hlfq.ExampleItems[0]  // Amount=1
+			high := hlfq.ExampleItems[1] // Amount=2
+			high.Priority = 5
+			first := expectcc.PayloadIs(invokeAt(ccMock.From(Authority), cc, "push1", txTime, "Push", defaultQueue, low),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			second := expectcc.PayloadIs(invokeAt(ccMock.From(Authority), cc, "push2", txTime, "Push", defaultQueue, high),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+
+			// the item moves to the priority 5 band
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("MoveAfter", defaultQueue, first.ID.String(), second.ID.String()))
+			Expect(stats().Count).To(Equal(2))
+			Expect(stats().AmountSum).To(Equal(3))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Someone), cc, "reserve", txTime, "Reserve", defaultQueue, 10))
+			expectcc.ResponseOk(invokeAt(ccMock.From(Someone), cc, "nack", txTime, "Nack", defaultQueue, second.ID.String()))
+			Expect(stats()).To(Equal(hlfq.QueueStats{
+				QueueName: defaultQueue, Count: 1, AmountSum: 1, OldestCreatedTime: txTime, DeadLetterCount: 1}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("DeleteQueue", defaultQueue))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("CreateQueue", defaultQueue))
+			Expect(stats()).To(Equal(hlfq.QueueStats{QueueName: defaultQueue}))
+		})
+	})
+
+	Describe("Verify and Repair", func() {
+		var (
+			ccMock *testcc.MockStub
+			items  []hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			cc := hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_verify", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			// pushes in different milliseconds, so ULID order is the push order
+			txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+			for i, spec := range hlfq.ExampleItems[0:4] {
+				expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, fmt.Sprintf("push%d", i), txTime.Add(time.Duration(i)*time.Second),
+					"Push", defaultQueue, spec))
+			}
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			items = expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		})
+
+		verify := func() hlfq.VerifyReport {
+			return expectcc.PayloadIs(ccMock.Invoke("Verify", defaultQueue), &hlfq.VerifyReport{}).(hlfq.VerifyReport)
+		}
+		itemKey := func(item hlfq.QueueItem) []string {
+			key, _ := item.Key()
+			return key
+		}
+
+		It("Reports no issues for a consistent queue", func() {
+			report := verify()
+			Expect(report.OK).To(BeTrue())
+			Expect(report.ItemCount).To(Equal(4))
+			Expect(report.LinkedCount).To(Equal(4))
+			Expect(report.Issues).To(BeEmpty())
+		})
+
+		It("Reports orphans after a broken link and appends them back on Repair", func() {
+			broken := items[1]
+			broken.NextKey = hlfq.EmptyItemPointerKey
+			putState(ccMock, itemKey(broken), broken)
+
+			report := verify()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.LinkedCount).To(Equal(2))
+			Expect(report.OrphanIDs).To(Equal([]string{items[2].ID.String(), items[3].ID.String()}))
+			Expect(report.Issues).To(ContainElement(hlfq.IntegrityIssue{
+				List: "0", ItemID: items[3].ID.String(), Problem: hlfq.IssueTailMismatch}))
+
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("Repair", defaultQueue), owner.ErrOwnerOnly)
+			repair := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Repair", defaultQueue), &hlfq.RepairReport{}).(hlfq.RepairReport)
+			Expect(repair.AppendedIDs).To(Equal(report.OrphanIDs))
+			Expect(repair.RelinkedIDs).To(Equal([]string{items[1].ID.String()}))
+
+			Expect(verify().OK).To(BeTrue())
+			Expect(expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{})).To(Equal(items))
+		})
+
+		It("Reports a cycle and cuts it on Repair", func() {
+			last := items[3]
+			last.NextKey = itemKey(items[0])
+			putState(ccMock, itemKey(last), last)
+
+			Expect(verify().Issues).To(ConsistOf(
+				hlfq.IntegrityIssue{List: "0", ItemID: items[3].ID.String(), Problem: hlfq.IssueCycle},
+				hlfq.IntegrityIssue{List: "0", ItemID: items[3].ID.String(), Problem: hlfq.IssueTailHasNext},
+			))
+
+			repair := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Repair", defaultQueue), &hlfq.RepairReport{}).(hlfq.RepairReport)
+			Expect(repair.RelinkedIDs).To(Equal([]string{items[3].ID.String()}))
+			Expect(repair.AppendedIDs).To(BeEmpty())
+			Expect(verify().OK).To(BeTrue())
+		})
+
+		It("Reports asymmetric links and wrong stats", func() {
+			last := items[3]
+			last.NextKey = itemKey(items[0])
+			putState(ccMock, itemKey(last), last)
+			third := items[2]
+			third.PrevKey = itemKey(items[0])
+			putState(ccMock, itemKey(third), third)
+			putState(ccMock, []string{"listStatsKey", defaultQueue, "0"}, nil)
+
+			report := verify()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Issues).To(ConsistOf(
+				hlfq.IntegrityIssue{List: "0", ItemID: items[2].ID.String(), Problem: hlfq.IssueAsymmetricLink},
+				hlfq.IntegrityIssue{List: "0", ItemID: items[3].ID.String(), Problem: hlfq.IssueTailHasNext},
+				hlfq.IntegrityIssue{List: "0", ItemID: items[3].ID.String(), Problem: hlfq.IssueTailMismatch},
+				hlfq.IntegrityIssue{List: "0", Problem: hlfq.IssueStatsMismatch},
+				hlfq.IntegrityIssue{List: "0", ItemID: items[2].ID.String(), Problem: hlfq.IssueOrphan},
+				hlfq.IntegrityIssue{List: "0", ItemID: items[3].ID.String(), Problem: hlfq.IssueOrphan},
+			))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Repair", defaultQueue))
+			Expect(verify().OK).To(BeTrue())
+			Expect(expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{})).To(Equal(items))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("Stats", defaultQueue), &hlfq.QueueStats{}).(hlfq.QueueStats).Count).
+				To(Equal(4))
+		})
+	})
+
+	Describe("Queue events", func() {
+		var (
+			cc     *router.Chaincode
+			ccMock *testcc.MockStub
+			events chan *peer.ChaincodeEvent
+		)
+
+		BeforeEach(func() {
+			cc = hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_events", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			events = ccMock.EventSubscription()
+		})
+
+		// nextEvent reads the next event from the mock stub event channel
+		nextEvent := func() hlfq.QueueEvent {
+			var event *peer.ChaincodeEvent
+			Expect(events).To(Receive(&event))
+			Expect(event.EventName).To(Equal(hlfq.QueueEventName))
+			return expectcc.EventPayloadIs(event, &hlfq.QueueEvent{}).(hlfq.QueueEvent)
+		}
+
+		It("Emits an event with the pushed item and the submitter", func() {
+			first := expectcc.PayloadIs(
+				ccMock.From(Someone).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: first.ID.String(), Operation: hlfq.ItemPushed}}))
+
+			second := expectcc.PayloadIs(
+				ccMock.From(Someone).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			event := nextEvent()
+			Expect(event.QueueName).To(Equal(defaultQueue))
+			Expect(event.Method).To(Equal("Push"))
+			Expect(event.Submitter.MSPID).To(Equal("SOME_MSP"))
+			// pushed items are linked later, so they have no neighbours
+			Expect(event.Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: second.ID.String(), Operation: hlfq.ItemPushed}}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: first.ID.String(), Operation: hlfq.ItemLinked, NewNextID: second.ID.String()},
+				{ItemID: second.ID.String(), Operation: hlfq.ItemLinked, NewPrevID: first.ID.String()},
+			}))
+		})
+
+		It("Fails the tx without the submitter", func() {
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]))
+			nextEvent()
+			expectcc.ResponseError(ccMock.Invoke("Pop", defaultQueue), "failed to get tx creator identity")
+			Expect(events).NotTo(Receive())
+		})
+
+		It("Emits events with old and new neighbours on Pop, AttachData and Move", func() {
+			items := make([]hlfq.QueueItem, 3)
+			for i := range items {
+				items[i] = expectcc.PayloadIs(
+					ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[i]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+				nextEvent()
+			}
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			nextEvent()
+			a, b, c := items[0].ID.String(), items[1].ID.String(), items[2].ID.String()
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("MoveAfter", defaultQueue, a, c))
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: a, Operation: hlfq.ItemMoved, OldNextID: b, NewPrevID: c}}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("MoveBefore", defaultQueue, c, b))
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: c, Operation: hlfq.ItemMoved, OldPrevID: b, OldNextID: a, NewNextID: b}}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("AttachData", defaultQueue, b, []byte("data")))
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: b, Operation: hlfq.ItemDataAttached, OldPrevID: c, OldNextID: a, NewPrevID: c, NewNextID: a}}))
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: c, Operation: hlfq.ItemPopped, OldNextID: b}}))
+		})
+
+		It("Combines all changes of the tx into one event", func() {
+			txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetMaxDeliveryAttempts", defaultQueue, 1))
+			first := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			second := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(invokeAt(ccMock.From(Someone), cc, "reserve", txTime, "Reserve", defaultQueue, 10))
+			for len(events) > 0 {
+				<-events
+			}
+
+			// the lease of the first item expires, Pop dead-letters it and pops the second one
+			expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, "pop", txTime.Add(time.Minute), "Pop", defaultQueue))
+			Expect(nextEvent().Changes).To(Equal([]hlfq.ItemChange{
+				{ItemID: first.ID.String(), Operation: hlfq.ItemDeadLettered, OldNextID: second.ID.String()},
+				{ItemID: second.ID.String(), Operation: hlfq.ItemPopped},
+			}))
+			Expect(events).NotTo(Receive())
+		})
+
+		It("Emits no event for queries and failed txs", func() {
+			expectcc.ResponseOk(ccMock.Invoke("ListItems", defaultQueue))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Pop", defaultQueue), "Empty queue")
+			Expect(events).NotTo(Receive())
+		})
+	})
+
+	Describe("Access control", func() {
+		var ccMock *testcc.MockStub
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_acl", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		It("Allows only listed MSP and the owner to call the method", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetACL", hlfq.ACL{
+				Method: "Push",
+				Allow:  []hlfq.ACLPrincipal{{MSPID: "OTHER_MSP"}},
+			}))
+
+			expectcc.ResponseError(
+				ccMock.From(Someone).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]), hlfq.ErrAccessDenied)
+			expectcc.ResponseOk(ccMock.From(OtherOrg).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))
+			// methods without ACL are open to anyone
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("Pop", defaultQueue))
+
+			acls := expectcc.PayloadIs(ccMock.Invoke("ListACL"), &[]hlfq.ACL{}).([]hlfq.ACL)
+			Expect(acls).To(HaveLen(1))
+			Expect(acls[0].Method).To(Equal("Push"))
+		})
+
+		It("Checks certificate attributes", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetACL", hlfq.ACL{
+				Method: "Select",
+				Allow:  []hlfq.ACLPrincipal{{MSPID: "SOME_MSP", Attribute: "role", Value: "auditor"}},
+			}))
+
+			// test certificates have no attributes
+			expectcc.ResponseError(
+				ccMock.From(Someone).Invoke("Select", defaultQueue, "{.Amount > 0}"), hlfq.ErrAccessDenied)
+		})
+
+		It("Opens the method again when ACL deleted", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetACL", hlfq.ACL{Method: "Push"}))
+			expectcc.ResponseError(
+				ccMock.From(OtherOrg).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]), hlfq.ErrAccessDenied)
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("DeleteACL", "Push"))
+			expectcc.ResponseOk(ccMock.From(OtherOrg).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]))
+		})
+
+		It("Allows only the owner to manage ACL", func() {
+			expectcc.ResponseError(
+				ccMock.From(Someone).Invoke("SetACL", hlfq.ACL{Method: "Push"}), owner.ErrOwnerOnly)
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("DeleteACL", "Push"), owner.ErrOwnerOnly)
+		})
+	})
+
+	Describe("Deterministic item IDs", func() {
+		txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+
+		newPeer := func(name string) (*testcc.MockStub, shim.Chaincode) {
+			cc := hlfq.New()
+			stub := testcc.NewMockStub(name, cc)
+			expectcc.ResponseOk(
+				invokeAt(stub.From(Authority), cc, "initTx", txTime, "init"))
+			return stub, cc
+		}
+
+		It("Produces the same write set for the same Push on different peers", func() {
+			peer1, cc1 := newPeer("peer1")
+			peer2, cc2 := newPeer("peer2")
+
+			item1 := expectcc.PayloadIs(
+				invokeAt(peer1.From(Authority), cc1, "pushTx", txTime, "Push", defaultQueue, hlfq.ExampleItems[0]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			item2 := expectcc.PayloadIs(
+				invokeAt(peer2.From(Authority), cc2, "pushTx", txTime, "Push", defaultQueue, hlfq.ExampleItems[0]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+
+			Expect(item1.ID).To(Equal(item2.ID))
+			Expect(peer1.Keys.Len()).To(Equal(peer2.Keys.Len()))
+			Expect(peer1.State).To(HaveLen(len(peer2.State)))
+			for key, value := range peer1.State {
+				Expect(peer2.State).To(HaveKeyWithValue(key, value), "state key "+key)
+			}
+		})
+
+		It("Generates different IDs for different transactions at the same time", func() {
+			peer1, cc1 := newPeer("peer1")
+			item1 := expectcc.PayloadIs(
+				invokeAt(peer1.From(Authority), cc1, "pushTx1", txTime, "Push", defaultQueue, hlfq.ExampleItems[0]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			item2 := expectcc.PayloadIs(
+				invokeAt(peer1.From(Authority), cc1, "pushTx2", txTime, "Push", defaultQueue, hlfq.ExampleItems[1]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+
+			Expect(item1.ID).NotTo(Equal(item2.ID))
+			Expect(item1.ID.Time()).To(Equal(item2.ID.Time()))
+		})
+	})
+
 	Describe("Inspect Queue", func() {
 
 		It("Allow to get queue items as list", func() {
 			// Add one item
 			testItems := hlfq.ExampleItems[0:3]
 			for _, ti := range testItems {
-				ccMockGlobal.From(Authority).Invoke("Push", ti)
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, ti)
 				// NOTE: if there is no sleep you would get an unexpected order of elemenst in List,
 				time.Sleep(time.Millisecond) // if no delay, Push() #3 of #2 can be executed before Push #1
 			}
 			//  &[]QueueItem{} - declares target type for unmarshalling from []byte received from chaincode
-			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			// fmt.Printf("  ***TEST_items=%+v\n", testItems)
 			// fmt.Printf("   ***     items=%+v\n", items)
 			Expect(items).To(HaveLen(3))
@@ -118,20 +1898,105 @@ var _ = Describe("HLFQueue", func() {
 		})
 	})
 
+	Describe("Paginated list", func() {
+		var (
+			ccMock *testcc.MockStub
+			all    []hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_pages", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			for i, priority := range []int{0, 5, 0, 5, 1} {
+				spec := hlfq.ExampleItems[i%len(hlfq.ExampleItems)]
+				spec.Priority = priority
+				expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, spec))
+			}
+			all = expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		})
+
+		It("Walks the queue page by page in the queue order", func() {
+			var (
+				items    []hlfq.QueueItem
+				bookmark string
+				pages    int
+			)
+			for {
+				page := expectcc.PayloadIs(
+					ccMock.Invoke("ListItemsPage", defaultQueue, bookmark, 2), &hlfq.ItemsPage{}).(hlfq.ItemsPage)
+				Expect(len(page.Items)).To(BeNumerically("<=", 2))
+				items = append(items, page.Items...)
+				pages++
+				if page.Bookmark == "" {
+					break
+				}
+				bookmark = page.Bookmark
+			}
+			Expect(pages).To(Equal(3))
+			Expect(items).To(Equal(all))
+		})
+
+		It("Returns no bookmark when the page ends at the queue tail", func() {
+			page := expectcc.PayloadIs(
+				ccMock.Invoke("ListItemsPage", defaultQueue, all[2].ID.String(), 2), &hlfq.ItemsPage{}).(hlfq.ItemsPage)
+			Expect(page.Items).To(Equal(all[3:]))
+			Expect(page.Bookmark).To(BeEmpty())
+		})
+
+		It("Checks the limit and the bookmark", func() {
+			expectcc.ResponseError(ccMock.Invoke("ListItemsPage", defaultQueue, "", 0), "Page limit must be")
+			expectcc.ResponseError(
+				ccMock.Invoke("ListItemsPage", defaultQueue, "", hlfq.MaxPageLimit+1), "Page limit must be")
+			expectcc.ResponseError(
+				ccMock.Invoke("ListItemsPage", defaultQueue, "not-an-id", 2), "failed to read bookmarked item")
+		})
+
+		It("Starts the page at the next ID if the bookmarked item is popped", func() {
+			cc := hlfq.New()
+			ccMock = testcc.NewMockStub("hlfq_pages_fifo", cc)
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			// pushes in distinct milliseconds, so the item IDs grow in the FIFO order
+			txTime := time.Date(2020, 5, 20, 10, 0, 0, 0, time.UTC)
+			for i := 0; i < 4; i++ {
+				expectcc.ResponseOk(invokeAt(ccMock.From(Authority), cc, fmt.Sprintf("push%d", i),
+					txTime.Add(time.Duration(i)*time.Millisecond), "Push", defaultQueue, hlfq.ExampleItems[i]))
+			}
+			fifo := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			page := expectcc.PayloadIs(
+				ccMock.Invoke("ListItemsPage", defaultQueue, "", 2), &hlfq.ItemsPage{}).(hlfq.ItemsPage)
+			Expect(page.Bookmark).To(Equal(fifo[1].ID.String()))
+
+			// the consumer pops the first page
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("PopN", defaultQueue, 2))
+			page = expectcc.PayloadIs(
+				ccMock.Invoke("ListItemsPage", defaultQueue, page.Bookmark, 2), &hlfq.ItemsPage{}).(hlfq.ItemsPage)
+			Expect(page.Items).To(HaveLen(2))
+			Expect(page.Items[0].ID).To(Equal(fifo[2].ID))
+			Expect(page.Items[1].ID).To(Equal(fifo[3].ID))
+			Expect(page.Bookmark).To(BeEmpty())
+
+			// no items after the popped bookmark
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("PopN", defaultQueue, 2))
+			page = expectcc.PayloadIs(
+				ccMock.Invoke("ListItemsPage", defaultQueue, fifo[1].ID.String(), 2), &hlfq.ItemsPage{}).(hlfq.ItemsPage)
+			Expect(page.Items).To(BeEmpty())
+		})
+	})
+
 	Describe("Attach extra context to item", func() {
 
 		It("Allow to add extra data to specified queue item", func() {
 			// lets Push 3 items into queue in reverse order
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // head
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // head
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", hlfq.ExampleItems[1]))
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))
 			// at the begin the test item has no ExtraData
 			Expect(hlfq.ExampleItems[1].ExtraData).To(Equal([]byte{}))
 			expectcc.ResponseOk(
-				ccMockGlobal.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // tail
+				ccMockGlobal.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // tail
 			// take a list
-			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			items := expectcc.PayloadIs(ccMockGlobal.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			// select item 1
 			item1 := items[1]
 			item1IDStr := item1.ID.String()
@@ -139,7 +2004,7 @@ var _ = Describe("HLFQueue", func() {
 			testExtraData := []byte("An extra data for " + item1IDStr)
 
 			updatedItem := expectcc.PayloadIs(
-				ccMockGlobal.From(Authority).Invoke("AttachData", item1IDStr, testExtraData),
+				ccMockGlobal.From(Authority).Invoke("AttachData", defaultQueue, item1IDStr, testExtraData),
 				&hlfq.QueueItem{}).(hlfq.QueueItem)
 
 			Expect(updatedItem.ID).To(Equal(item1.ID))
@@ -157,17 +2022,17 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[3])) // Amount=4
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3])) // Amount=4
 
 			queryStr := "{.Amount > 1 and .Amount < 4}"
 			filteredItems := expectcc.PayloadIs(
-				ccMock2.From(Authority).Invoke("Select", queryStr),
+				ccMock2.From(Authority).Invoke("Select", defaultQueue, queryStr),
 				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 
 			Expect(filteredItems).To(HaveLen(2))
@@ -182,17 +2047,17 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[3])) // Amount=4
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3])) // Amount=4
 
 			queryStr := "{.Amount > 100 }"
 			filteredItems := expectcc.PayloadIs(
-				ccMock2.From(Authority).Invoke("Select", queryStr),
+				ccMock2.From(Authority).Invoke("Select", defaultQueue, queryStr),
 				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 
 			Expect(filteredItems).To(HaveLen(0))
@@ -205,18 +2070,18 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[3])) // Amount=4
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3])) // Amount=4
 
 			From := "A"
 			queryStr := fmt.Sprintf("{.From == '%s' }", From)
 			filteredItems := expectcc.PayloadIs(
-				ccMock2.From(Authority).Invoke("Select", queryStr),
+				ccMock2.From(Authority).Invoke("Select", defaultQueue, queryStr),
 				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 
 			Expect(filteredItems).To(HaveLen(2))
@@ -233,19 +2098,19 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			expectcc.ResponseOk(
-				ccMock2.From(Authority).Invoke("Push", hlfq.ExampleItems[3])) // Amount=4
+				ccMock2.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3])) // Amount=4
 
 			From := "A"
 			Amount := 2
 			queryStr := fmt.Sprintf("{.From == '%s' and .Amount > %d }", From, Amount)
 			filteredItems := expectcc.PayloadIs(
-				ccMock2.From(Authority).Invoke("Select", queryStr),
+				ccMock2.From(Authority).Invoke("Select", defaultQueue, queryStr),
 				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 
 			Expect(filteredItems).To(HaveLen(1))
@@ -255,6 +2120,572 @@ var _ = Describe("HLFQueue", func() {
 
 	})
 
+	Describe("Item creator", func() {
+		var ccMock *testcc.MockStub
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_creator", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		actor := func(id *identity.CertIdentity) hlfq.Actor {
+			return hlfq.Actor{MSPID: id.GetMSPIdentifier(), Subject: id.GetSubject()}
+		}
+
+		It("Records the tx creator of every pushed item", func() {
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(pushed.Creator).To(Equal(actor(Authority)))
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("PushBatch", defaultQueue, hlfq.ExampleItems[1:3]))
+			expectcc.ResponseOk(ccMock.From(OtherOrg).Invoke("PushFront", defaultQueue, hlfq.ExampleItems[3]))
+
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(4))
+			Expect(items[0].Creator).To(Equal(actor(OtherOrg)))
+			Expect(items[1].Creator).To(Equal(actor(Authority)))
+			Expect(items[2].Creator).To(Equal(actor(Someone)))
+			Expect(items[3].Creator).To(Equal(actor(Someone)))
+
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.Creator).To(Equal(actor(OtherOrg)))
+		})
+
+		It("Refuses to push without the tx creator", func() {
+			expectcc.ResponseError(ccMock.Invoke("Push", defaultQueue, hlfq.ExampleItems[0]),
+				"failed to get tx creator identity")
+			expectcc.ResponseError(ccMock.Invoke("PushBatch", defaultQueue, hlfq.ExampleItems[1:3]),
+				"failed to get tx creator identity")
+			expectcc.ResponseError(ccMock.Invoke("PushFront", defaultQueue, hlfq.ExampleItems[3]),
+				"failed to get tx creator identity")
+		})
+
+		It("Selects items by the creator", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]))
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))
+			expectcc.ResponseOk(ccMock.From(OtherOrg).Invoke("Push", defaultQueue, hlfq.ExampleItems[2]))
+
+			selected := expectcc.PayloadIs(ccMock.Invoke("Select", defaultQueue, `{.Creator.MSPID == "SOME_MSP"}`),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[0].Amount).To(Equal(1))
+			Expect(selected[1].Amount).To(Equal(2))
+
+			query := fmt.Sprintf(`{.Creator.Subject == %q}`, Someone.GetSubject())
+			selected = expectcc.PayloadIs(ccMock.Invoke("Select", defaultQueue, query),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[0].Creator).To(Equal(actor(Someone)))
+		})
+	})
+
+	Describe("Item ownership", func() {
+		var (
+			ccMock              *testcc.MockStub
+			own, other, foreign hlfq.QueueItem
+		)
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_ownership", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			own = expectcc.PayloadIs(ccMock.From(Someone).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			other = expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			foreign = expectcc.PayloadIs(ccMock.From(OtherOrg).Invoke("Push", defaultQueue, hlfq.ExampleItems[2]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+		})
+
+		It("Allows the creator to change the item", func() {
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("AttachData", defaultQueue, own.ID.String(), []byte("data")))
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("MoveAfter", defaultQueue, own.ID.String(), other.ID.String()))
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("MoveBefore", defaultQueue, own.ID.String(), other.ID.String()))
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("Remove", defaultQueue, own.ID.String()))
+		})
+
+		It("Denies changing an item of another creator", func() {
+			for _, id := range []string{other.ID.String(), foreign.ID.String()} {
+				expectcc.ResponseError(ccMock.From(Someone).Invoke("AttachData", defaultQueue, id, []byte("data")),
+					hlfq.ErrPermissionDenied)
+				expectcc.ResponseError(ccMock.From(Someone).Invoke("MoveAfter", defaultQueue, id, own.ID.String()),
+					hlfq.ErrPermissionDenied)
+				expectcc.ResponseError(ccMock.From(Someone).Invoke("MoveBefore", defaultQueue, id, own.ID.String()),
+					hlfq.ErrPermissionDenied)
+				expectcc.ResponseError(ccMock.From(Someone).Invoke("Remove", defaultQueue, id), hlfq.ErrPermissionDenied)
+			}
+			// the same subject in another MSP is another identity
+			expectcc.ResponseError(ccMock.From(OtherOrg).Invoke("Remove", defaultQueue, own.ID.String()),
+				hlfq.ErrPermissionDenied)
+
+			items := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items).To(HaveLen(3))
+			for i, item := range []hlfq.QueueItem{own, other, foreign} {
+				Expect(items[i].ID).To(Equal(item.ID))
+				Expect(items[i].ExtraData).To(Equal(item.ExtraData))
+			}
+		})
+
+		It("Allows a queue admin to change any item", func() {
+			// the chaincode owner is a queue admin
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("AttachData", defaultQueue, own.ID.String(), []byte("data")))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("MoveBefore", defaultQueue, foreign.ID.String(), own.ID.String()))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, own.ID.String()))
+		})
+
+		It("Allows identities matching the admin principals of the owner to change any item", func() {
+			admins := hlfq.QueueAdmins{Allow: []hlfq.ACLPrincipal{{MSPID: "OTHER_MSP"}}}
+			expectcc.ResponseError(ccMock.From(OtherOrg).Invoke("SetQueueAdmins", admins), owner.ErrOwnerOnly)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetQueueAdmins", admins))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("GetQueueAdmins"), &hlfq.QueueAdmins{})).To(Equal(admins))
+
+			expectcc.ResponseOk(ccMock.From(OtherOrg).Invoke("AttachData", defaultQueue, own.ID.String(), []byte("data")))
+			expectcc.ResponseOk(ccMock.From(OtherOrg).Invoke("Remove", defaultQueue, other.ID.String()))
+			// an identity of another MSP is not an admin
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("Remove", defaultQueue, foreign.ID.String()),
+				hlfq.ErrPermissionDenied)
+		})
+
+		It("Doesn't make an identity an admin by the role attribute without the admin principal of its MSP", func() {
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("SetQueueAdmins", hlfq.QueueAdmins{
+				Allow: []hlfq.ACLPrincipal{{Attribute: hlfq.AdminRoleAttribute, Value: hlfq.AdminRoleValue}}}),
+				"Empty MSPID of queue admin principal #0")
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetQueueAdmins", hlfq.QueueAdmins{
+				Allow: []hlfq.ACLPrincipal{{MSPID: "SOME_MSP", Attribute: hlfq.AdminRoleAttribute, Value: hlfq.AdminRoleValue}}}))
+
+			// the test certificates have no role attribute
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("Remove", defaultQueue, foreign.ID.String()),
+				hlfq.ErrPermissionDenied)
+			expectcc.ResponseError(ccMock.From(OtherOrg).Invoke("Remove", defaultQueue, own.ID.String()),
+				hlfq.ErrPermissionDenied)
+			// the owner is an admin anyway
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Remove", defaultQueue, foreign.ID.String()))
+		})
+
+		It("Reports a missing item", func() {
+			expectcc.ResponseOk(ccMock.From(Someone).Invoke("Remove", defaultQueue, own.ID.String()))
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("Remove", defaultQueue, own.ID.String()),
+				"failed to cut item")
+		})
+	})
+
+	Describe("Private ExtraData", func() {
+		const collection = "queueExtraData"
+		var (
+			ccMock  *testcc.MockStub
+			salt    = []byte("0123456789abcdef")
+			readers = []string{"SOME_MSP"}
+		)
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_private", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetPrivateCollection", defaultQueue, collection, readers))
+		})
+
+		// specs without public ExtraData
+		specs := make([]hlfq.QueueItemSpec, len(hlfq.ExampleItems))
+		for i, spec := range hlfq.ExampleItems {
+			spec.ExtraData = nil
+			specs[i] = spec
+		}
+		hash := func(data string) string {
+			h := sha256.Sum256(append(append([]byte{}, salt...), data...))
+			return hex.EncodeToString(h[:])
+		}
+		privateData := func(item hlfq.QueueItem) string {
+			private := expectcc.PayloadIs(ccMock.From(Someone).Query("GetPrivateExtraData", defaultQueue, item.ID.String()),
+				&hlfq.PrivateExtraData{}).(hlfq.PrivateExtraData)
+			return string(private.ExtraData)
+		}
+		// withData returns the transient map with private ExtraData and its salt
+		withData := func(data string) map[string][]byte {
+			return map[string][]byte{hlfq.TransientExtraDataKey: []byte(data), hlfq.TransientExtraDataSaltKey: salt}
+		}
+		// publicState shows the data is not stored in the public state
+		publicState := func() string {
+			var values []string
+			for _, value := range ccMock.State {
+				values = append(values, string(value))
+			}
+			return strings.Join(values, "\n")
+		}
+
+		It("Keeps ExtraData passed in the transient map in the collection", func() {
+			transient := withData("secret")
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(transient).Invoke("Push", defaultQueue,
+				specs[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(pushed.ExtraData).To(BeEmpty())
+			Expect(pushed.PrivateCollection).To(Equal(collection))
+			Expect(pushed.ExtraDataHash).To(Equal(hash("secret")))
+			Expect(ccMock.PvtState[collection]).To(HaveLen(1))
+			Expect(publicState()).NotTo(ContainSubstring(base64.StdEncoding.EncodeToString([]byte("secret"))))
+			Expect(privateData(pushed)).To(Equal("secret"))
+
+			batch := map[string][]byte{"ExtraData.0": []byte("first"), "ExtraData.1": []byte("second"),
+				hlfq.TransientExtraDataSaltKey: salt}
+			items := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(batch).Invoke("PushBatch", defaultQueue,
+				specs[1:3]), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(privateData(items[0])).To(Equal("first"))
+			Expect(privateData(items[1])).To(Equal("second"))
+			Expect(items[1].ExtraDataHash).To(Equal(hash("second")))
+
+			front := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(transient).Invoke("PushFront", defaultQueue,
+				specs[3]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(privateData(front)).To(Equal("secret"))
+			Expect(ccMock.PvtState[collection]).To(HaveLen(4))
+		})
+
+		It("Refuses public ExtraData", func() {
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]),
+				"ExtraData of queue 'default' must be passed in the transient map")
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PushBatch", defaultQueue, hlfq.ExampleItems[0:2]), "invalid item #0")
+
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue,
+				specs[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseError(ccMock.From(Someone).Query("GetPrivateExtraData", defaultQueue, pushed.ID.String()),
+				fmt.Sprintf("Item '%s' has no private ExtraData", pushed.ID))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("AttachData", defaultQueue, pushed.ID.String(),
+				[]byte("data")), "ExtraData of queue 'default' must be passed in the transient map")
+		})
+
+		It("Hashes the data with the salt kept in the collection", func() {
+			expectcc.ResponseError(ccMock.From(Authority).WithTransient(map[string][]byte{
+				hlfq.TransientExtraDataKey: []byte("secret")}).Invoke("Push", defaultQueue, specs[0]),
+				"ExtraData salt of at least 16 bytes must be passed in the transient map")
+			expectcc.ResponseError(ccMock.From(Authority).WithTransient(map[string][]byte{
+				hlfq.TransientExtraDataKey: []byte("secret"), hlfq.TransientExtraDataSaltKey: []byte("short")}).Invoke(
+				"Push", defaultQueue, specs[0]), "ExtraData salt of at least 16 bytes must be passed in the transient map")
+
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withData("secret")).Invoke("Push",
+				defaultQueue, specs[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(pushed.ExtraDataHash).NotTo(Equal(fmt.Sprintf("%x", sha256.Sum256([]byte("secret")))))
+			private := expectcc.PayloadIs(ccMock.From(Someone).Query("GetPrivateExtraData", defaultQueue,
+				pushed.ID.String()), &hlfq.PrivateExtraData{}).(hlfq.PrivateExtraData)
+			Expect(private.Salt).To(Equal(salt))
+		})
+
+		It("Allows only reader MSPs to read private data", func() {
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("SetPrivateCollection", defaultQueue, collection,
+				[]string{}), "Private collection of queue 'default' must have readers")
+
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withData("secret")).Invoke("Push",
+				defaultQueue, specs[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseError(ccMock.From(OtherOrg).Query("GetPrivateExtraData", defaultQueue, pushed.ID.String()),
+				"access denied: MSP 'OTHER_MSP' can not read private ExtraData of queue 'default'")
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetPrivateCollection", defaultQueue, collection,
+				[]string{"SOME_MSP", "OTHER_MSP"}))
+			private := expectcc.PayloadIs(ccMock.From(OtherOrg).Query("GetPrivateExtraData", defaultQueue,
+				pushed.ID.String()), &hlfq.PrivateExtraData{}).(hlfq.PrivateExtraData)
+			Expect(string(private.ExtraData)).To(Equal("secret"))
+		})
+
+		It("Replaces private data by AttachData and deletes it with the item", func() {
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withData("secret")).Invoke("Push",
+				defaultQueue, specs[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			attached := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withData("updated")).Invoke("AttachData",
+				defaultQueue, pushed.ID.String(), []byte{}), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(attached.ExtraData).To(BeEmpty())
+			Expect(attached.ExtraDataHash).To(Equal(hash("updated")))
+			Expect(privateData(attached)).To(Equal("updated"))
+
+			// new data is public when the collection is turned off
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetPrivateCollection", defaultQueue, "", readers))
+			attached = expectcc.PayloadIs(ccMock.From(Authority).Invoke("AttachData", defaultQueue, pushed.ID.String(),
+				[]byte("public")), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(string(attached.ExtraData)).To(Equal("public"))
+			Expect(attached.PrivateCollection).To(BeEmpty())
+			Expect(ccMock.PvtState[collection]).To(BeEmpty())
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetPrivateCollection", defaultQueue, collection, readers))
+			for i := 0; i < 2; i++ {
+				expectcc.ResponseOk(ccMock.From(Authority).WithTransient(withData("secret")).Invoke("Push",
+					defaultQueue, specs[i]))
+			}
+			Expect(ccMock.PvtState[collection]).To(HaveLen(2))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue)) // the public item
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Pop", defaultQueue))
+			Expect(ccMock.PvtState[collection]).To(HaveLen(1))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("DeleteQueue", defaultQueue))
+			Expect(ccMock.PvtState[collection]).To(BeEmpty())
+		})
+
+		It("Checks validation rules against private data", func() {
+			rules := []hlfq.FieldRule{{Field: "ExtraData", Required: true, MaxLength: 8}}
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue, rules))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.QueueItemSpec{From: "A", To: "B"}),
+				"Validation failed: ExtraData: is required")
+			expectcc.ResponseError(ccMock.From(Authority).WithTransient(withData("too long data")).Invoke("Push", defaultQueue,
+				hlfq.QueueItemSpec{From: "A", To: "B"}), "Validation failed: ExtraData: must be at most 8 long")
+		})
+	})
+
+	Describe("Encrypted ExtraData", func() {
+		var (
+			ccMock   *testcc.MockStub
+			key      = []byte("0123456789abcdef0123456789abcdef")
+			withKey  = encryption.TransientMapWithKey(key)
+			otherKey = encryption.TransientMapWithKey([]byte("fedcba9876543210fedcba9876543210"))
+		)
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_encryption", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		It("Encrypts ExtraData with the key from the transient map", func() {
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("Push", defaultQueue,
+				hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(pushed.ExtraDataEncrypted).To(BeTrue())
+			Expect(pushed.ExtraData).NotTo(Equal(hlfq.ExampleItems[0].ExtraData))
+			Expect(encryption.Decrypt(key, pushed.ExtraData)).To(Equal(hlfq.ExampleItems[0].ExtraData))
+
+			batch := []hlfq.QueueItemSpec{hlfq.ExampleItems[3], hlfq.ExampleItems[1]}
+			items := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("PushBatch", defaultQueue, batch),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items[0].ExtraDataEncrypted).To(BeTrue())
+			Expect(encryption.Decrypt(key, items[0].ExtraData)).To(Equal(hlfq.ExampleItems[3].ExtraData))
+			// empty data is not encrypted
+			Expect(items[1].ExtraDataEncrypted).To(BeFalse())
+
+			// without the key the data is stored as is
+			plain := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3]),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(plain.ExtraDataEncrypted).To(BeFalse())
+			Expect(plain.ExtraData).To(Equal(hlfq.ExampleItems[3].ExtraData))
+
+			attached := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("AttachData",
+				defaultQueue, pushed.ID.String(), []byte("data")), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(attached.ExtraDataEncrypted).To(BeTrue())
+			Expect(encryption.Decrypt(key, attached.ExtraData)).To(Equal([]byte("data")))
+
+			expectcc.ResponseError(ccMock.From(Authority).WithTransient(encryption.TransientMapWithKey([]byte("short"))).Invoke(
+				"Push", defaultQueue, hlfq.ExampleItems[0]), "failed to encrypt ExtraData")
+		})
+
+		It("Encrypts equal ExtraData of different items to different ciphertexts", func() {
+			batch := []hlfq.QueueItemSpec{hlfq.ExampleItems[0], hlfq.ExampleItems[0]}
+			items := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("PushBatch", defaultQueue, batch),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("Push", defaultQueue,
+				hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			items = append(items, pushed)
+
+			for i, item := range items {
+				Expect(encryption.Decrypt(key, item.ExtraData)).To(Equal(hlfq.ExampleItems[0].ExtraData))
+				for _, other := range items[:i] {
+					Expect(item.ExtraData).NotTo(Equal(other.ExtraData))
+					// the IV is the first block
+					Expect(item.ExtraData[:16]).NotTo(Equal(other.ExtraData[:16]))
+				}
+			}
+		})
+
+		It("Decrypts ExtraData in query results only with the key", func() {
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("Push", defaultQueue,
+				hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1]))
+
+			peeked := expectcc.PayloadIs(ccMock.Query("Peek", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(peeked.ExtraData).To(Equal(pushed.ExtraData))
+			peeked = expectcc.PayloadIs(ccMock.WithTransient(withKey).Query("Peek", defaultQueue),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(peeked.ExtraData).To(Equal(hlfq.ExampleItems[0].ExtraData))
+			Expect(peeked.ExtraDataEncrypted).To(BeFalse())
+
+			page := expectcc.PayloadIs(ccMock.WithTransient(withKey).Query("ListItemsPage", defaultQueue, "", 10),
+				&hlfq.ItemsPage{}).(hlfq.ItemsPage)
+			Expect(page.Items[0].ExtraData).To(Equal(hlfq.ExampleItems[0].ExtraData))
+			Expect(page.Items[1].ExtraData).To(Equal(hlfq.ExampleItems[1].ExtraData))
+
+			items := expectcc.PayloadIs(ccMock.Query("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items[0].ExtraData).To(Equal(pushed.ExtraData))
+			items = expectcc.PayloadIs(ccMock.WithTransient(withKey).Query("ListItems", defaultQueue),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(items[0].ExtraData).To(Equal(hlfq.ExampleItems[0].ExtraData))
+			Expect(items[0].ExtraDataEncrypted).To(BeFalse())
+			Expect(items[1].ExtraData).To(Equal(hlfq.ExampleItems[1].ExtraData))
+
+			// Select filters by not encrypted fields
+			selected := expectcc.PayloadIs(ccMock.Query("Select", defaultQueue, `{.From == "A"}`),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(selected).To(HaveLen(1))
+			Expect(selected[0].ExtraData).To(Equal(pushed.ExtraData))
+			selected = expectcc.PayloadIs(ccMock.WithTransient(withKey).Query("Select", defaultQueue, `{.From == "A"}`),
+				&[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			Expect(selected[0].ExtraData).To(Equal(hlfq.ExampleItems[0].ExtraData))
+
+			expectcc.ResponseError(ccMock.WithTransient(otherKey).Query("Peek", defaultQueue), "failed to decrypt ExtraData")
+
+			// invoke responses are stored in the block, so Pop keeps the data encrypted
+			popped := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(withKey).Invoke("Pop", defaultQueue),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ExtraData).To(Equal(pushed.ExtraData))
+		})
+
+		It("Encrypts private ExtraData", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetPrivateCollection", defaultQueue, "queueExtraData",
+				[]string{"SOME_MSP"}))
+			spec := hlfq.ExampleItems[0]
+			spec.ExtraData = nil
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).WithTransient(map[string][]byte{
+				encryption.TransientMapKey: key, hlfq.TransientExtraDataKey: []byte("secret"),
+				hlfq.TransientExtraDataSaltKey: []byte("0123456789abcdef")}).Invoke("Push", defaultQueue, spec),
+				&hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(pushed.ExtraDataEncrypted).To(BeTrue())
+
+			private := expectcc.PayloadIs(ccMock.From(Someone).Query("GetPrivateExtraData", defaultQueue,
+				pushed.ID.String()), &hlfq.PrivateExtraData{}).(hlfq.PrivateExtraData)
+			Expect(encryption.Decrypt(key, private.ExtraData)).To(Equal([]byte("secret")))
+			private = expectcc.PayloadIs(ccMock.From(Someone).WithTransient(withKey).Query("GetPrivateExtraData", defaultQueue,
+				pushed.ID.String()), &hlfq.PrivateExtraData{}).(hlfq.PrivateExtraData)
+			Expect(string(private.ExtraData)).To(Equal("secret"))
+		})
+	})
+
+	Describe("Payload", func() {
+		var ccMock *testcc.MockStub
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_payload", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		selectItems := func(queryStr string) []hlfq.QueueItem {
+			return expectcc.PayloadIs(
+				ccMock.Invoke("Select", defaultQueue, queryStr), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+		}
+
+		It("Stores an arbitrary JSON payload with the item", func() {
+			spec := hlfq.ExampleItems[0]
+			spec.Payload = map[string]interface{}{
+				"customer": "X", "total": 10.5, "tags": []interface{}{"a", "b"},
+				"address": map[string]interface{}{"city": "Moscow"}}
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue, spec), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(pushed.Payload).To(Equal(spec.Payload))
+			Expect(pushed.From).To(Equal(spec.From))
+
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.Payload).To(Equal(spec.Payload))
+		})
+
+		It("Selects items by payload fields", func() {
+			for i, customer := range []string{"X", "Y", "X"} {
+				spec := hlfq.ExampleItems[i]
+				spec.Payload = map[string]interface{}{"customer": customer, "total": i * 10,
+					"address": map[string]interface{}{"city": customer + "-city"}}
+				expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, spec))
+			}
+			// an item without payload
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3]))
+
+			selected := selectItems(`{.Payload.customer == "X"}`)
+			Expect(selected).To(HaveLen(2))
+			Expect(selected[0].Amount).To(Equal(1))
+			Expect(selected[1].Amount).To(Equal(3))
+
+			Expect(selectItems(`{.Payload.customer == "X" and .Amount > 1}`)).To(HaveLen(1))
+			// a missing nested object must be checked before its fields
+			Expect(selectItems(`{.Payload.address != nil and .Payload.address.city == "Y-city"}`)).To(HaveLen(1))
+			expectcc.ResponseError(ccMock.Invoke("Select", defaultQueue, `{.Payload.address.city == "Y-city"}`),
+				"failed filter operation")
+			Expect(selectItems(`{.Payload.total != nil and .Payload.total >= 10}`)).To(HaveLen(2))
+			Expect(selectItems(`{.Payload == nil}`)).To(HaveLen(1))
+		})
+
+		It("Reads items stored without payload", func() {
+			pushed := expectcc.PayloadIs(
+				ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0]), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Compact", defaultQueue))
+			item := expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)[0]
+
+			// the item as it was stored before Payload was added
+			var old map[string]interface{}
+			bb, err := json.Marshal(item)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(bb, &old)).To(Succeed())
+			delete(old, "Payload")
+			key, _ := item.Key()
+			putState(ccMock, key, old)
+
+			Expect(selectItems(`{.Payload.customer == "X"}`)).To(BeEmpty())
+			popped := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Pop", defaultQueue), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			Expect(popped.ID).To(Equal(pushed.ID))
+			Expect(popped.Payload).To(BeNil())
+		})
+	})
+
+	Describe("Validation rules", func() {
+		var ccMock *testcc.MockStub
+		zero, hundred := 0.0, 100.0
+		rules := []hlfq.FieldRule{
+			{Field: "From", Required: true},
+			{Field: "To", Required: true, Pattern: "^[A-Z]+$"},
+			{Field: "Amount", Min: &zero},
+			{Field: "ExtraData", MaxLength: 8},
+			{Field: "Payload.customer", Required: true, Type: hlfq.TypeString},
+			{Field: "Payload.total", Max: &hundred},
+		}
+		valid := func() hlfq.QueueItemSpec {
+			return hlfq.QueueItemSpec{From: "A", To: "B", Amount: 1,
+				Payload: map[string]interface{}{"customer": "X", "total": 10}}
+		}
+
+		BeforeEach(func() {
+			ccMock = testcc.NewMockStub("hlfq_validation", hlfq.New())
+			expectcc.ResponseOk(ccMock.From(Authority).Init())
+		})
+
+		It("Allows only the owner to set valid rules", func() {
+			expectcc.ResponseError(ccMock.From(Someone).Invoke("SetValidationRules", defaultQueue, rules),
+				owner.ErrOwnerOnly)
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue,
+				[]hlfq.FieldRule{{Field: "From"}, {Field: "Sender"}}), "invalid rule #1: Unknown field 'Sender'")
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue,
+				[]hlfq.FieldRule{{Field: "To", Pattern: "("}}), "invalid rule #0: invalid pattern")
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue, rules))
+			stored := expectcc.PayloadIs(ccMock.Invoke("GetValidationRules", defaultQueue),
+				&hlfq.ValidationRules{}).(hlfq.ValidationRules)
+			Expect(stored.Rules).To(Equal(rules))
+		})
+
+		It("Rejects a pushed item listing every failed field", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue, rules))
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, valid()))
+
+			spec := valid()
+			spec.From, spec.To, spec.Amount = "", "b", -1
+			spec.Payload = map[string]interface{}{"customer": 1, "total": 200}
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Push", defaultQueue, spec), "Validation failed: "+
+				"From: is required; To: must match '^[A-Z]+$'; Amount: must be at least 0; "+
+				"Payload.customer: must be string; Payload.total: must be at most 100")
+
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("PushBatch", defaultQueue, []hlfq.QueueItemSpec{valid(), {From: "A", To: "B"}}),
+				"invalid item #1: Validation failed: Payload.customer: is required")
+			Expect(expectcc.PayloadIs(ccMock.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{})).To(HaveLen(1))
+		})
+
+		It("Checks attached data", func() {
+			pushed := expectcc.PayloadIs(ccMock.From(Authority).Invoke("Push", defaultQueue, valid()), &hlfq.QueueItem{}).(hlfq.QueueItem)
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue, rules))
+
+			expectcc.ResponseError(
+				ccMock.From(Authority).Invoke("AttachData", defaultQueue, pushed.ID.String(), []byte("too long data")),
+				"Validation failed: ExtraData: must be at most 8 long")
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("AttachData", defaultQueue, pushed.ID.String(), []byte("data")))
+		})
+
+		It("Accepts any item when the rules are deleted", func() {
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue, rules))
+			expectcc.ResponseError(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.QueueItemSpec{}), "Validation failed")
+
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("SetValidationRules", defaultQueue, []hlfq.FieldRule{}))
+			Expect(expectcc.PayloadIs(ccMock.Invoke("GetValidationRules", defaultQueue),
+				&hlfq.ValidationRules{}).(hlfq.ValidationRules).Rules).To(BeEmpty())
+			expectcc.ResponseOk(ccMock.From(Authority).Invoke("Push", defaultQueue, hlfq.QueueItemSpec{}))
+		})
+	})
+
 	Describe("Items Rrordering :: MoveAfter", func() {
 
 		It("Allows to move an item to the place AFTER specified item in the middle", func() {
@@ -264,22 +2695,22 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[3])) // Amount=4
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3])) // Amount=4
 			// We expect to see Amout list: 1, 3, 2, 4
-			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(itemsInQueue).To(HaveLen(4))
 
 			movingItem := itemsInQueue[1] // Amount = 2
 			afterItem := itemsInQueue[2]  // Amount = 3
 
 			movedItem := expectcc.PayloadIs(
-				ccMock3.From(Authority).Invoke("MoveAfter", movingItem.ID.String(), afterItem.ID.String()),
+				ccMock3.From(Authority).Invoke("MoveAfter", defaultQueue, movingItem.ID.String(), afterItem.ID.String()),
 				&hlfq.QueueItem{}).(hlfq.QueueItem)
 
 			// check method returned the same item that was passed in
@@ -288,7 +2719,7 @@ var _ = Describe("HLFQueue", func() {
 			Expect(movedItem.ID.String()).To(Equal(movingItem.ID.String()))
 
 			// check list is reordered
-			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(reorderedList).To(HaveLen(len(itemsInQueue)))
 
 			Expect(reorderedList[0].Amount).To(Equal(itemsInQueue[0].Amount), "#1 ID"+reorderedList[0].ID.String())
@@ -304,18 +2735,18 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			// We expect to see Amout list: 1, 3, 2, 4
-			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(itemsInQueue).To(HaveLen(2), "Added 2 items to queue")
 
 			movingItem := itemsInQueue[0] // Amount = 2
 			afterItem := itemsInQueue[1]  // Amount = 3
 
 			movedItem := expectcc.PayloadIs(
-				ccMock3.From(Authority).Invoke("MoveAfter", movingItem.ID.String(), afterItem.ID.String()),
+				ccMock3.From(Authority).Invoke("MoveAfter", defaultQueue, movingItem.ID.String(), afterItem.ID.String()),
 				&hlfq.QueueItem{}).(hlfq.QueueItem)
 
 			// check method returned the same item that was passed in
@@ -324,7 +2755,7 @@ var _ = Describe("HLFQueue", func() {
 			Expect(movedItem.ID.String()).To(Equal(movingItem.ID.String()))
 
 			// check list is reordered
-			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(reorderedList).To(HaveLen(len(itemsInQueue)), "Want same items conunt in queue after reorder")
 
 			Expect(reorderedList[0].Amount).To(Equal(itemsInQueue[1].Amount), "#1 ID"+reorderedList[0].ID.String())
@@ -338,20 +2769,20 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			// We expect to see Amout list: 1, 3, 2, 4
-			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(itemsInQueue).To(HaveLen(3), "Added 3 items to queue")
 
 			movingItem := itemsInQueue[0] // Amount = 2
 			afterItem := itemsInQueue[2]  // Amount = 3
 
 			movedItem := expectcc.PayloadIs(
-				ccMock3.From(Authority).Invoke("MoveAfter", movingItem.ID.String(), afterItem.ID.String()),
+				ccMock3.From(Authority).Invoke("MoveAfter", defaultQueue, movingItem.ID.String(), afterItem.ID.String()),
 				&hlfq.QueueItem{}).(hlfq.QueueItem)
 
 			// check method returned the same item that was passed in
@@ -360,7 +2791,7 @@ var _ = Describe("HLFQueue", func() {
 			Expect(movedItem.ID.String()).To(Equal(movingItem.ID.String()))
 
 			// check list is reordered
-			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(reorderedList).To(HaveLen(len(itemsInQueue)), "Want same items conunt in queue after reorder")
 
 			Expect(reorderedList[0].Amount).To(Equal(itemsInQueue[1].Amount), "#1 ID"+reorderedList[0].ID.String())
@@ -377,22 +2808,22 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[2])) // Amount=3
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[2])) // Amount=3
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[3])) // Amount=4
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[3])) // Amount=4
 			// We expect to see Amout list: 1, 3, 2, 4
-			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(itemsInQueue).To(HaveLen(4))
 
 			movingItem := itemsInQueue[2] // Amount = 3
 			beforeItem := itemsInQueue[1] // Amount = 2
 
 			movedItem := expectcc.PayloadIs(
-				ccMock3.From(Authority).Invoke("MoveBefore", movingItem.ID.String(), beforeItem.ID.String()),
+				ccMock3.From(Authority).Invoke("MoveBefore", defaultQueue, movingItem.ID.String(), beforeItem.ID.String()),
 				&hlfq.QueueItem{}).(hlfq.QueueItem)
 
 			// check method returned the same item that was passed in
@@ -401,7 +2832,7 @@ var _ = Describe("HLFQueue", func() {
 			Expect(movedItem.ID.String()).To(Equal(movingItem.ID.String()))
 
 			// check list is reordered
-			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(reorderedList).To(HaveLen(len(itemsInQueue)))
 
 			Expect(reorderedList[0].Amount).To(Equal(itemsInQueue[0].Amount), "#1 ID"+reorderedList[0].ID.String())
@@ -417,18 +2848,18 @@ var _ = Describe("HLFQueue", func() {
 
 			// Push 3 items
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[0])) // Amount=1
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[0])) // Amount=1
 			expectcc.ResponseOk(
-				ccMock3.From(Authority).Invoke("Push", hlfq.ExampleItems[1])) // Amount=2
+				ccMock3.From(Authority).Invoke("Push", defaultQueue, hlfq.ExampleItems[1])) // Amount=2
 
-			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			itemsInQueue := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(itemsInQueue).To(HaveLen(2), "Added 2 items to queue")
 
 			movingItem := itemsInQueue[1] // Amount = 2
 			beforeItem := itemsInQueue[0] // Amount = 1
 
 			movedItem := expectcc.PayloadIs(
-				ccMock3.From(Authority).Invoke("MoveBefore", movingItem.ID.String(), beforeItem.ID.String()),
+				ccMock3.From(Authority).Invoke("MoveBefore", defaultQueue, movingItem.ID.String(), beforeItem.ID.String()),
 				&hlfq.QueueItem{}).(hlfq.QueueItem)
 
 			// check method returned the same item that was passed in
@@ -437,7 +2868,7 @@ var _ = Describe("HLFQueue", func() {
 			Expect(movedItem.ID.String()).To(Equal(movingItem.ID.String()))
 
 			// check list is reordered
-			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems"), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
+			reorderedList := expectcc.PayloadIs(ccMock3.Invoke("ListItems", defaultQueue), &[]hlfq.QueueItem{}).([]hlfq.QueueItem)
 			Expect(reorderedList).To(HaveLen(len(itemsInQueue)), "Want same items conunt in queue after reorder")
 
 			Expect(reorderedList[0].Amount).To(Equal(itemsInQueue[1].Amount), "#1 ID"+reorderedList[0].ID.String())
@@ -0,0 +1,234 @@
+package hlfq
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+	"github.com/s7techlab/cckit/state"
+)
+
+// An endorsing peer doesn't show the writes of a tx to the tx itself: GetState returns the value committed
+// before the tx. Middlewares and methods change the same keys one after another (linkPending links items
+// which Pop then reads, PopN pops one head after another), so the writes of the tx are kept in memory by txStub:
+// reads of written keys and key ranges see them, and every written key goes to the peer once
+// when the method succeeds.
+
+// context store key of the txStub of the tx
+const txStubKey = "txStub"
+
+// txWrite is the last write of the key made by the tx
+type txWrite struct {
+	collection string // empty for the public state
+	key        string
+	value      []byte // nil for a deleted key
+}
+
+type txWriteKey struct {
+	collection string
+	key        string
+}
+
+// txStub keeps the writes of the tx in memory until flush
+type txStub struct {
+	shim.ChaincodeStubInterface
+	writes map[txWriteKey]*txWrite
+	order  []*txWrite // in the order of the first write
+}
+
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+	return &txStub{ChaincodeStubInterface: stub, writes: map[txWriteKey]*txWrite{}}
+}
+
+// txState is a router middleware makes the method read the writes of its tx, see txStub
+func txState(next router.HandlerFunc, pos ...int) router.HandlerFunc {
+	return func(c router.Context) (interface{}, error) {
+		stub := newTxStub(c.Stub())
+		c.Set(txStubKey, stub)
+		c.UseState(state.NewState(stub, c.Logger()))
+		res, err := next(c)
+		if err != nil {
+			return res, err
+		}
+		if err := stub.flush(); err != nil {
+			return nil, errors.Wrap(err, "failed to write tx state")
+		}
+		return res, nil
+	}
+}
+
+// stateStub returns the stub reading the writes of the tx, the peer stub if the method is called without txState
+func stateStub(c router.Context) shim.ChaincodeStubInterface {
+	if stub, ok := c.Get(txStubKey).(*txStub); ok {
+		return stub
+	}
+	return c.Stub()
+}
+
+func (s *txStub) write(collection, key string, value []byte) {
+	k := txWriteKey{collection: collection, key: key}
+	if w, ok := s.writes[k]; ok {
+		w.value = value
+		return
+	}
+	w := &txWrite{collection: collection, key: key, value: value}
+	s.writes[k] = w
+	s.order = append(s.order, w)
+}
+
+func (s *txStub) GetState(key string) ([]byte, error) {
+	if w, ok := s.writes[txWriteKey{key: key}]; ok {
+		return w.value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *txStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("empty state key")
+	}
+	s.write("", key, value)
+	return nil
+}
+
+func (s *txStub) DelState(key string) error {
+	s.write("", key, nil)
+	return nil
+}
+
+func (s *txStub) GetPrivateData(collection, key string) ([]byte, error) {
+	if w, ok := s.writes[txWriteKey{collection: collection, key: key}]; ok {
+		return w.value, nil
+	}
+	return s.ChaincodeStubInterface.GetPrivateData(collection, key)
+}
+
+func (s *txStub) PutPrivateData(collection, key string, value []byte) error {
+	if collection == "" || key == "" {
+		return errors.New("empty private data collection or key")
+	}
+	s.write(collection, key, value)
+	return nil
+}
+
+func (s *txStub) DelPrivateData(collection, key string) error {
+	s.write(collection, key, nil)
+	return nil
+}
+
+// GetStateByPartialCompositeKey returns the committed keys of the range merged with the writes of the tx,
+// the committed keys are read while the caller iterates, so the peer records the range up to the last read key
+func (s *txStub) GetStateByPartialCompositeKey(objectType string, attrs []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attrs)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, attrs)
+	if err != nil {
+		return nil, err
+	}
+	var writes []*txWrite
+	for _, w := range s.order {
+		if w.collection == "" && strings.HasPrefix(w.key, prefix) {
+			writes = append(writes, w)
+		}
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].key < writes[j].key })
+	return &txRangeIterator{iter: iter, writes: writes}, nil
+}
+
+// flush passes the writes of the tx to the peer, returns *StateError on failure
+func (s *txStub) flush() error {
+	for _, w := range s.order {
+		var err error
+		switch {
+		case w.collection == "" && w.value == nil:
+			err = s.ChaincodeStubInterface.DelState(w.key)
+		case w.collection == "":
+			err = s.ChaincodeStubInterface.PutState(w.key, w.value)
+		case w.value == nil:
+			err = s.ChaincodeStubInterface.DelPrivateData(w.collection, w.key)
+		default:
+			err = s.ChaincodeStubInterface.PutPrivateData(w.collection, w.key, w.value)
+		}
+		if err != nil {
+			op := StatePut
+			if w.value == nil {
+				op = StateDelete
+			}
+			return newStateError(op, s.stateKey(w.key), err)
+		}
+	}
+	return nil
+}
+
+// stateKey returns parts of the composite key
+func (s *txStub) stateKey(key string) []string {
+	objectType, attrs, err := s.SplitCompositeKey(key)
+	if err != nil || objectType == "" {
+		return []string{key}
+	}
+	return append([]string{objectType}, attrs...)
+}
+
+// txRangeIterator merges the committed keys of the range with the writes of the tx sorted by key
+type txRangeIterator struct {
+	iter      shim.StateQueryIteratorInterface
+	committed *queryresult.KV // the next committed key, read ahead
+	writes    []*txWrite
+	next      *queryresult.KV
+	err       error // the read error, returned by Next
+}
+
+// advance finds the next key of the merged range, nil at the end
+func (i *txRangeIterator) advance() error {
+	for i.next == nil && i.err == nil {
+		if i.committed == nil && i.iter.HasNext() {
+			kv, err := i.iter.Next()
+			if err != nil {
+				i.err = err
+				break
+			}
+			i.committed = kv
+		}
+		switch {
+		case i.committed == nil && len(i.writes) == 0:
+			return nil
+		case len(i.writes) == 0 || (i.committed != nil && i.committed.Key < i.writes[0].key):
+			i.next, i.committed = i.committed, nil
+		default:
+			w := i.writes[0]
+			i.writes = i.writes[1:]
+			if i.committed != nil && i.committed.Key == w.key {
+				i.committed = nil // overwritten by the tx
+			}
+			if w.value != nil {
+				i.next = &queryresult.KV{Key: w.key, Value: w.value}
+			}
+		}
+	}
+	return i.err
+}
+
+func (i *txRangeIterator) HasNext() bool {
+	return i.advance() != nil || i.next != nil
+}
+
+func (i *txRangeIterator) Next() (*queryresult.KV, error) {
+	if err := i.advance(); err != nil {
+		return nil, err
+	}
+	if i.next == nil {
+		return nil, errors.New("no more keys in the range")
+	}
+	next := i.next
+	i.next = nil
+	return next, nil
+}
+
+func (i *txRangeIterator) Close() error {
+	return i.iter.Close()
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/oklog/ulid/v2"
 	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/identity"
 	"github.com/s7techlab/cckit/router"
 )
 
@@ -32,8 +33,8 @@ import (
 }*/
 
 // read current key of head item
-func readHeadItemKey(c router.Context) (headKey []string, err error) {
-	pointerKey, _ := NewQueueHeadPointer().Key()
+func readHeadItemKey(c router.Context, l itemList) (headKey []string, err error) {
+	pointerKey, _ := l.headPointer().Key()
 	headPointer, err := readQueuePointer(c, pointerKey)
 	if err != nil {
 		return headKey, errors.Wrap(err, "failed to read key of a head item")
@@ -44,8 +45,8 @@ func readHeadItemKey(c router.Context) (headKey []string, err error) {
 }
 
 // read current key of tail item
-func readTailItemKey(c router.Context) (tailKey []string, err error) {
-	pointerKey, _ := NewQueueTailPointer().Key()
+func readTailItemKey(c router.Context, l itemList) (tailKey []string, err error) {
+	pointerKey, _ := l.tailPointer().Key()
 	tailPointer, err := readQueuePointer(c, pointerKey)
 	if err != nil {
 		return tailKey, errors.Wrap(err, "failed to read key of a tail item")
@@ -56,33 +57,36 @@ func readTailItemKey(c router.Context) (tailKey []string, err error) {
 }
 
 // replace a tail pointer with itemKey
-func setHeadPointerTo(c router.Context, itemKey []string) (err error) {
+func setHeadPointerTo(c router.Context, l itemList, itemKey []string) (err error) {
 	// fmt.Printf("\n::--STORE HEAD: %v\n\n", itemKey)
-	headPointer := NewQueueHeadPointer()
+	headPointer := l.headPointer()
 	headPointer.PointerKey = itemKey
-	if err := c.State().Put(headPointer); err != nil {
+	if err := putState(c, headPointer); err != nil {
 		return errors.Wrap(err, "failed to update queue head pointer")
 	}
 	return nil
 }
 
 // replace a tail pointer with itemKey
-func setTailPointerTo(c router.Context, itemKey []string) (err error) {
+func setTailPointerTo(c router.Context, l itemList, itemKey []string) (err error) {
 	// fmt.Printf("\n--::STORE TAIL: %v\n\n", itemKey)
-	tailPointer := NewQueueTailPointer()
+	tailPointer := l.tailPointer()
 	tailPointer.PointerKey = itemKey
 
-	if err := c.State().Put(tailPointer); err != nil {
-		return errors.Wrap(err, "failed to update queue head pointer")
+	if err := putState(c, tailPointer); err != nil {
+		return errors.Wrap(err, "failed to update queue tail pointer")
 	}
 	return nil
 }
 
-func getHeadItem(c router.Context) (headItem QueueItem, err error) {
-	headItemKey, err := readHeadItemKey(c)
+func getHeadItem(c router.Context, l itemList) (headItem QueueItem, err error) {
+	headItemKey, err := readHeadItemKey(c, l)
 	if err != nil {
 		return headItem, err
 	}
+	if isKeyEmpty(headItemKey) {
+		return headItem, ErrEmptyQueue
+	}
 	headItem, err = readQueueItem(c, headItemKey)
 	if err != nil {
 		return headItem, errors.Wrap(err, "failed to load head item")
@@ -90,14 +94,14 @@ func getHeadItem(c router.Context) (headItem QueueItem, err error) {
 	return headItem, nil
 }
 
-func getTailItem(c router.Context) (tailItem QueueItem, err error) {
-	tailItemKey, err := readTailItemKey(c)
+func getTailItem(c router.Context, l itemList) (tailItem QueueItem, err error) {
+	tailItemKey, err := readTailItemKey(c, l)
 	if err != nil {
 		return tailItem, err
 	}
 
 	if isKeyEmpty(tailItemKey) {
-		return tailItem, errors.New("Empty queue")
+		return tailItem, ErrEmptyQueue
 	}
 
 	tailItem, err = readQueueItem(c, tailItemKey)
@@ -107,8 +111,8 @@ func getTailItem(c router.Context) (tailItem QueueItem, err error) {
 	return tailItem, nil
 }
 
-func hasTail(c router.Context) (bool, error) {
-	tailKey, err := readTailItemKey(c)
+func hasTail(c router.Context, l itemList) (bool, error) {
+	tailKey, err := readTailItemKey(c, l)
 	if err != nil {
 		return false, err
 	}
@@ -118,8 +122,8 @@ func hasTail(c router.Context) (bool, error) {
 	return true, nil
 }
 
-func hasHead(c router.Context) (bool, error) {
-	headKey, err := readHeadItemKey(c)
+func hasHead(c router.Context, l itemList) (bool, error) {
+	headKey, err := readHeadItemKey(c, l)
 	if err != nil {
 		return false, err
 	}
@@ -129,6 +133,137 @@ func hasHead(c router.Context) (bool, error) {
 	return true, nil
 }
 
+// queueLists returns priority bands of the queue in the serving order, the highest priority first
+func queueLists(queueName string) []itemList {
+	lists := make([]itemList, 0, MaxPriority-MinPriority+1)
+	for p := MaxPriority; p >= MinPriority; p-- {
+		lists = append(lists, itemList{QueueName: queueName, Priority: p})
+	}
+	return lists
+}
+
+// walkList visits items of one list from head to tail until fn returns stop or error
+func walkList(c router.Context, l itemList, fn func(item QueueItem) (stop bool, err error)) (stopped bool, err error) {
+	headKey, err := readHeadItemKey(c, l)
+	if err != nil {
+		return false, err
+	}
+	return walkListFrom(c, headKey, fn)
+}
+
+// walkListFrom visits items from the item with the key to the tail of its list until fn returns stop or error
+func walkListFrom(c router.Context, nextKey []string, fn func(item QueueItem) (stop bool, err error)) (stopped bool, err error) {
+	for !isKeyEmpty(nextKey) {
+		item, err := readQueueItem(c, nextKey)
+		if err != nil {
+			return false, errors.Wrap(err, "failed read next item")
+		}
+		if stop, err := fn(item); stop || err != nil {
+			return stop, err
+		}
+		nextKey = item.NextKey
+	}
+	return false, nil
+}
+
+// walkQueue visits queue items in the effective order (priority bands from the highest, each from head to tail
+// followed by pending items of the band) until fn returns stop or error
+func walkQueue(c router.Context, queueName string, fn func(item QueueItem) (stop bool, err error)) error {
+	pending := lazyPendingByList(c, queueName)
+	for _, l := range queueLists(queueName) {
+		if stopped, err := walkList(c, l, fn); stopped || err != nil {
+			return err
+		}
+		items, err := pending(l)
+		if err != nil {
+			return err
+		}
+		if stopped, err := walkPending(items, fn); stopped || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkQueueAfter visits queue items following the item in the effective order until fn returns stop or error,
+// afterPending shows the item is a pending one
+func walkQueueAfter(c router.Context, after QueueItem, afterPending bool,
+	fn func(item QueueItem) (stop bool, err error)) error {
+	pending := lazyPendingByList(c, after.QueueName)
+	for _, l := range queueLists(after.QueueName) {
+		var stopped bool
+		var err error
+		switch {
+		case l.Priority > after.Priority: // already visited
+			continue
+		case l.Priority == after.Priority && afterPending:
+			// linked items are before the pending ones
+		case l.Priority == after.Priority:
+			stopped, err = walkListFrom(c, after.NextKey, fn)
+		default:
+			stopped, err = walkList(c, l, fn)
+		}
+		if stopped || err != nil {
+			return err
+		}
+		rest, err := pending(l)
+		if err != nil {
+			return err
+		}
+		if l.Priority == after.Priority && afterPending {
+			// skip pending items up to the item
+			for i, item := range rest {
+				if item.ID == after.ID {
+					rest = rest[i+1:]
+					break
+				}
+			}
+		}
+		if stopped, err := walkPending(rest, fn); stopped || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkQueueBackward visits queue items in the reverse effective order (priority bands from the lowest,
+// each from the last pending item to the head) until fn returns stop or error
+func walkQueueBackward(c router.Context, queueName string, fn func(item QueueItem) (stop bool, err error)) error {
+	pending := lazyPendingByList(c, queueName)
+	lists := queueLists(queueName)
+	for i := len(lists) - 1; i >= 0; i-- {
+		items, err := pending(lists[i])
+		if err != nil {
+			return err
+		}
+		for j := len(items) - 1; j >= 0; j-- {
+			if stop, err := fn(items[j]); stop || err != nil {
+				return err
+			}
+		}
+		tailKey, err := readTailItemKey(c, lists[i])
+		if err != nil {
+			return err
+		}
+		for prevKey := tailKey; !isKeyEmpty(prevKey); {
+			item, err := readQueueItem(c, prevKey)
+			if err != nil {
+				return errors.Wrap(err, "failed read prev item")
+			}
+			if stop, err := fn(item); stop || err != nil {
+				return err
+			}
+			prevKey = item.PrevKey
+		}
+	}
+	return nil
+}
+
+// deadLetterList returns the list of dead-lettered items of the queue
+func deadLetterList(queueName string) itemList {
+	return itemList{QueueName: queueName, DeadLetter: true}
+}
+
 func isKeyEmpty(key []string) bool {
 	return reflect.DeepEqual(key, EmptyItemPointerKey)
 }
@@ -136,56 +271,55 @@ func isKeyEmpty(key []string) bool {
 func readQueueItem(c router.Context, itemKey []string) (item QueueItem, err error) {
 	res, err := c.State().Get(itemKey, &QueueItem{})
 	if err != nil {
-		return item, errors.Wrapf(err, "failed to read QueueItem with key '%v'", itemKey)
+		return item, errors.Wrap(newStateError(StateGet, itemKey, err), "failed to read QueueItem")
 	}
 	item = res.(QueueItem)
 	return item, nil
 }
 
-func readQueueItemByID(c router.Context, itemIDStr string) (item QueueItem, err error) {
+func readQueueItemByID(c router.Context, queueName string, itemIDStr string) (item QueueItem, err error) {
 	id, err := ulid.ParseStrict(itemIDStr)
 	if err != nil {
 		return item, errors.Wrap(err, "invalid ULID string passed")
 	}
-	itemForKey := QueueItem{ID: id}
+	itemForKey := QueueItem{QueueName: queueName, ID: id}
 	itemKey, _ := itemForKey.Key()
 	res, err := c.State().Get(itemKey, &QueueItem{})
 	if err != nil {
-		return item, errors.Wrapf(err, "failed to read QueueItem with ID '%s'", itemIDStr)
+		return item, errors.Wrapf(newStateError(StateGet, itemKey, err), "failed to read QueueItem with ID '%s'", itemIDStr)
 	}
 	item = res.(QueueItem)
 	return item, nil
 }
 
+// a missing pointer points to nothing, pointers of a priority band are stored on the first push to the band
 func readQueuePointer(c router.Context, key []string) (pointerItem QueuePointer, err error) {
-	res, err := c.State().Get(key, &QueuePointer{})
+	res, err := c.State().Get(key, &QueuePointer{}, QueuePointer{PointerKey: EmptyItemPointerKey})
 	if err != nil {
-		return pointerItem, errors.Wrapf(err, "failed to read QueuePointer with key '%v'", key)
+		return pointerItem, errors.Wrap(newStateError(StateGet, key, err), "failed to read QueuePointer")
 	}
 	pointerItem = res.(QueuePointer)
 	return pointerItem, nil
 }
 
 // connect left item to right
-func connectItems(c router.Context, leftIDStr string, rightIDStr string) (err error) {
-	leftItem, err := readQueueItemByID(c, leftIDStr)
+func connectItems(c router.Context, queueName string, leftIDStr string, rightIDStr string) (err error) {
+	leftItem, err := readQueueItemByID(c, queueName, leftIDStr)
 	if err != nil {
 		return errors.Wrapf(err, "failed load leftItem ID '%s'", leftIDStr)
 	}
 	leftItemKey, _ := leftItem.Key()
-	rightItem, err := readQueueItemByID(c, rightIDStr)
+	rightItem, err := readQueueItemByID(c, queueName, rightIDStr)
 	if err != nil {
 		return errors.Wrapf(err, "failed load rightItem ID '%s'", rightIDStr)
 	}
 	rightItemKey, _ := rightItem.Key()
 	leftItem.NextKey = rightItemKey
 	rightItem.PrevKey = leftItemKey
-	err = c.State().Put(leftItem)
-	if err != nil {
+	if err := putState(c, leftItem); err != nil {
 		return errors.Wrap(err, "faild to save leftItem")
 	}
-	c.State().Put(rightItem)
-	if err != nil {
+	if err := putState(c, rightItem); err != nil {
 		return errors.Wrap(err, "faild to save rightItem")
 	}
 	return nil
@@ -196,27 +330,36 @@ func connectItems(c router.Context, leftIDStr string, rightIDStr string) (err er
 // PrevItem.NextKey replaced to item.NextKey,
 // NextItem.PrevKey replaced to item.PrevKey
 // Updates Head and Tail pointer if item is a head or tail item
-func cutItem(c router.Context, itemIDStr string) (item QueueItem, err error) {
-	item, err = readQueueItemByID(c, itemIDStr)
+func cutItem(c router.Context, queueName string, itemIDStr string) (item QueueItem, err error) {
+	item, err = readQueueItemByID(c, queueName, itemIDStr)
 	if err != nil {
 		return item, errors.Wrapf(err, "failed load item ID '%s'", itemIDStr)
 	}
 
+	countItem(c, item.list(), item, -1)
+
 	// check if item is a Head, so we need to replace HeadPointer
-	if isHeadPointsTo(c, item) {
+	isHead, err := isHeadPointsTo(c, item)
+	if err != nil {
+		return item, err
+	}
+	if isHead {
 		// move head pointer to next item (list=X[head]<->Y => list=Y[Head], cut=X)
-		setHeadPointerTo(c, item.NextKey) // TODO: handle error
-		// headItem2, _ := getHeadItem(c)    // TODO: handle error
-		// fmt.Printf("***NEW HeadID=%s\n", headItem2.ID.String())
+		if err := setHeadPointerTo(c, item.list(), item.NextKey); err != nil {
+			return item, err
+		}
 	}
 
 	// check if item is a Tail, so we need to replace TailPointer
-
-	if isTailPointsTo(c, item) {
+	isTail, err := isTailPointsTo(c, item)
+	if err != nil {
+		return item, err
+	}
+	if isTail {
 		// set tail pointer to prevous item (list=X->Y[Tail] => list=X[Tail], cut=Y)
-		setTailPointerTo(c, item.PrevKey) // TODO: handle error
-		// tailItem2, _ := getTailItem(c)
-		// fmt.Printf("--> NEW TailID=%s\n", tailItem2.ID.String())
+		if err := setTailPointerTo(c, item.list(), item.PrevKey); err != nil {
+			return item, err
+		}
 	}
 
 	// prev <- item -> next
@@ -232,7 +375,9 @@ func cutItem(c router.Context, itemIDStr string) (item QueueItem, err error) {
 		// fmt.Printf("*** prevItem.OLD=%+v\n", prevItem)
 		prevItem.NextKey = item.NextKey
 		// save updated prevItem
-		c.State().Put(prevItem) // TODO: handle error
+		if err := putState(c, prevItem); err != nil {
+			return item, errors.Wrapf(err, "failed to save prev item for ID '%s'", itemIDStr)
+		}
 		// fmt.Printf("--> prevItem.NEW=%+v\n", prevItem)
 	}
 	// update NextID of an item before targetItem if present
@@ -245,19 +390,120 @@ func cutItem(c router.Context, itemIDStr string) (item QueueItem, err error) {
 		// fmt.Printf("*** nextItem.OLD=%+v\n", nextItem)
 		nextItem.PrevKey = item.PrevKey
 		// save updated nextItem
-		c.State().Put(nextItem) // TODO: handle error
+		if err := putState(c, nextItem); err != nil {
+			return item, errors.Wrapf(err, "failed to save next item for ID '%s'", itemIDStr)
+		}
 
 		// fmt.Printf("*** nextItem.NEW=%+v\n", nextItem)
 	}
 	return item, nil
 }
 
-func isHeadPointsTo(c router.Context, item QueueItem) bool {
-	headItem, _ := getHeadItem(c) // TODO: handle error
-	return headItem.ID.Compare(item.ID) == 0
+// linkToTail links the item after the tail item of its list and sets the tail pointer to it,
+// the item links are updated but the item is not saved
+func linkToTail(c router.Context, item *QueueItem) error {
+	return linkAllToTail(c, item.list(), []*QueueItem{item})
+}
+
+// linkAllToTail links the items of the list one after another after the tail item of the list.
+// The previous tail item and the list pointers are written once for all items,
+// the item links are updated but the items are not saved
+func linkAllToTail(c router.Context, l itemList, items []*QueueItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	for i, item := range items {
+		item.PrevKey = EmptyItemPointerKey
+		item.NextKey = EmptyItemPointerKey
+		if i > 0 {
+			items[i-1].NextKey, _ = item.Key() // PREV->CUR
+			item.PrevKey, _ = items[i-1].Key() // PREV<-CUR
+		}
+		countItem(c, l, *item, 1)
+	}
+	firstKey, _ := items[0].Key()
+	lastKey, _ := items[len(items)-1].Key()
+
+	tailPresent, err := hasTail(c, l)
+	if err != nil {
+		return err
+	}
+	if tailPresent {
+		tailItem, err := getTailItem(c, l)
+		if err != nil {
+			return err
+		}
+		tailItem.NextKey = firstKey           // TAIL->FIRST
+		items[0].PrevKey, _ = tailItem.Key() // TAIL<-FIRST
+		if err := putState(c, tailItem); err != nil {
+			return errors.Wrap(err, "failed to update previous tail item")
+		}
+	} else {
+		// empty list, the first item becomes the head too
+		if err := setHeadPointerTo(c, l, firstKey); err != nil {
+			return err
+		}
+	}
+	return setTailPointerTo(c, l, lastKey)
+}
+
+// linkToHead links the item before the head item of its list and sets the head pointer to it,
+// the item links are updated but the item is not saved
+func linkToHead(c router.Context, item *QueueItem) error {
+	l := item.list()
+	itemKey, _ := item.Key()
+	item.PrevKey = EmptyItemPointerKey
+	item.NextKey = EmptyItemPointerKey
+	countItem(c, l, *item, 1)
+
+	headPresent, err := hasHead(c, l)
+	if err != nil {
+		return err
+	}
+	if headPresent {
+		headItem, err := getHeadItem(c, l)
+		if err != nil {
+			return err
+		}
+		headItem.PrevKey = itemKey       // CUR<-HEAD
+		item.NextKey, _ = headItem.Key() // CUR->HEAD
+		if err := putState(c, headItem); err != nil {
+			return errors.Wrap(err, "failed to update previous head item")
+		}
+	} else {
+		// empty list, item becomes the tail too
+		if err := setTailPointerTo(c, l, itemKey); err != nil {
+			return err
+		}
+	}
+	return setHeadPointerTo(c, l, itemKey)
 }
 
-func isTailPointsTo(c router.Context, item QueueItem) bool {
-	tailItem, _ := getTailItem(c) // TODO: handle error
-	return tailItem.ID.Compare(item.ID) == 0
+// isHeadPointsTo checks the head pointer of the item list points to the item
+func isHeadPointsTo(c router.Context, item QueueItem) (bool, error) {
+	headKey, err := readHeadItemKey(c, item.list())
+	if err != nil {
+		return false, err
+	}
+	itemKey, _ := item.Key()
+	return reflect.DeepEqual(headKey, itemKey), nil
+}
+
+// isTailPointsTo checks the tail pointer of the item list points to the item
+func isTailPointsTo(c router.Context, item QueueItem) (bool, error) {
+	tailKey, err := readTailItemKey(c, item.list())
+	if err != nil {
+		return false, err
+	}
+	itemKey, _ := item.Key()
+	return reflect.DeepEqual(tailKey, itemKey), nil
+}
+
+// invokerActor returns MSP ID and certificate subject of the tx creator
+func invokerActor(c router.Context) (actor Actor, err error) {
+	invoker, err := identity.FromStub(c.Stub())
+	if err != nil {
+		return actor, errors.Wrap(err, "failed to get tx creator identity")
+	}
+	return Actor{MSPID: invoker.GetMSPIdentifier(), Subject: invoker.GetSubject()}, nil
 }
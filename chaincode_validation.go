@@ -0,0 +1,265 @@
+package hlfq
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+const (
+	validationRulesKeyPrefix = "validationRulesKey"
+	validationRulesParam     = "rules"
+	// payloadFieldPrefix starts a rule field addressing a payload field, nested fields are separated by dots
+	payloadFieldPrefix = "Payload."
+)
+
+// Rules checked by FieldRule, reported in FieldError
+const (
+	RuleRequired  = "required"
+	RuleType      = "type"
+	RuleMin       = "min"
+	RuleMax       = "max"
+	RuleMinLength = "minLength"
+	RuleMaxLength = "maxLength"
+	RulePattern   = "pattern"
+)
+
+// JSON types of payload fields checked by FieldRule.Type
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+	TypeArray   = "array"
+)
+
+// FieldRule constrains one field of a pushed item.
+// Field is From, To, Amount, ExtraData, Payload or Payload.<field>[.<field>...].
+// Checks other than Required are skipped for a missing (empty) field.
+type FieldRule struct {
+	Field    string `json:"Field"`
+	Required bool   `json:"Required"`
+	// Type is a JSON type of a payload field, optional
+	Type string `json:"Type"`
+	// Min and Max limit a number, optional
+	Min *float64 `json:"Min"`
+	Max *float64 `json:"Max"`
+	// MinLength and MaxLength limit a string (in characters), ExtraData (in bytes) or an array, 0 means no limit
+	MinLength int `json:"MinLength"`
+	MaxLength int `json:"MaxLength"`
+	// Pattern is a regular expression a string must match, optional
+	Pattern string `json:"Pattern"`
+}
+
+// ValidationRules are rules checked by Push, PushBatch and AttachData of the queue before writing an item
+type ValidationRules struct {
+	QueueName string      `json:"QueueName"`
+	Rules     []FieldRule `json:"Rules"`
+}
+
+// Key for ValidationRules entry in chaincode state
+func (v ValidationRules) Key() ([]string, error) {
+	return []string{validationRulesKeyPrefix, v.QueueName}, nil
+}
+
+// FieldError is a failed rule of an item field
+type FieldError struct {
+	Field   string `json:"Field"`
+	Rule    string `json:"Rule"`
+	Message string `json:"Message"`
+}
+
+// ValidationError lists all failed rules of an item.
+// Use errors.Cause(err).(*ValidationError) to get the failed fields.
+type ValidationError struct {
+	Fields []FieldError `json:"Fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = fmt.Sprintf("%s: %s", f.Field, f.Message)
+	}
+	return "Validation failed: " + strings.Join(msgs, "; ")
+}
+
+// queueSetValidationRules stores rules of the queue, replaces existing ones, empty rules delete them
+// arg1 -> queueName string
+// arg2 -> rules []FieldRule
+func queueSetValidationRules(c router.Context) (interface{}, error) {
+	rules := ValidationRules{QueueName: c.ParamString(queueNameParam), Rules: c.Param(validationRulesParam).([]FieldRule)}
+	if len(rules.Rules) == 0 {
+		return rules, deleteState(c, rules)
+	}
+	for i, rule := range rules.Rules {
+		if err := rule.check(); err != nil {
+			return nil, errors.Wrapf(err, "invalid rule #%d", i)
+		}
+	}
+	if err := putState(c, rules); err != nil {
+		return nil, errors.Wrap(err, "failed to save validation rules")
+	}
+	return rules, nil
+}
+
+// queueGetValidationRules returns rules of the queue, empty if not set
+// arg1 -> queueName string
+func queueGetValidationRules(c router.Context) (interface{}, error) {
+	return readValidationRules(c, c.ParamString(queueNameParam))
+}
+
+func readValidationRules(c router.Context, queueName string) (rules ValidationRules, err error) {
+	empty := ValidationRules{QueueName: queueName, Rules: []FieldRule{}}
+	res, err := c.State().Get(empty, &ValidationRules{}, empty)
+	if err != nil {
+		return rules, errors.Wrap(newStateError(StateGet, empty, err), "failed to read validation rules")
+	}
+	return res.(ValidationRules), nil
+}
+
+// validate checks the item against all rules, returns *ValidationError listing every failed rule
+func (v ValidationRules) validate(spec QueueItemSpec) error {
+	var failed []FieldError
+	for _, rule := range v.Rules {
+		failed = append(failed, rule.validate(spec)...)
+	}
+	if len(failed) > 0 {
+		return &ValidationError{Fields: failed}
+	}
+	return nil
+}
+
+// check validates the rule itself
+func (r FieldRule) check() error {
+	switch {
+	case r.Field == "From" || r.Field == "To" || r.Field == "Amount" || r.Field == "ExtraData" || r.Field == "Payload":
+	case strings.HasPrefix(r.Field, payloadFieldPrefix) && len(r.Field) > len(payloadFieldPrefix):
+	default:
+		return errors.Errorf("Unknown field '%s'", r.Field)
+	}
+	switch r.Type {
+	case "", TypeString, TypeNumber, TypeBoolean, TypeObject, TypeArray:
+	default:
+		return errors.Errorf("Unknown type '%s'", r.Type)
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return errors.New("Min is greater than Max")
+	}
+	if r.MinLength < 0 || r.MaxLength < 0 || (r.MaxLength > 0 && r.MinLength > r.MaxLength) {
+		return errors.New("Invalid length limits")
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return errors.Wrap(err, "invalid pattern")
+	}
+	return nil
+}
+
+// validate returns failed rules of the item field
+func (r FieldRule) validate(spec QueueItemSpec) (failed []FieldError) {
+	fail := func(rule string, format string, args ...interface{}) {
+		failed = append(failed, FieldError{Field: r.Field, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+	value, present := r.value(spec)
+	if !present {
+		if r.Required {
+			fail(RuleRequired, "is required")
+		}
+		return failed
+	}
+	if r.Type != "" && jsonType(value) != r.Type {
+		fail(RuleType, "must be %s", r.Type)
+		return failed
+	}
+
+	if r.Min != nil || r.Max != nil {
+		n, ok := value.(float64)
+		switch {
+		case !ok:
+			fail(RuleType, "must be %s", TypeNumber)
+		case r.Min != nil && n < *r.Min:
+			fail(RuleMin, "must be at least %v", *r.Min)
+		case r.Max != nil && n > *r.Max:
+			fail(RuleMax, "must be at most %v", *r.Max)
+		}
+	}
+
+	if r.MinLength > 0 || r.MaxLength > 0 {
+		var length int
+		switch v := value.(type) {
+		case string:
+			length = utf8.RuneCountInString(v)
+		case []byte:
+			length = len(v)
+		case []interface{}:
+			length = len(v)
+		default:
+			fail(RuleType, "must have a length")
+			return failed
+		}
+		if length < r.MinLength {
+			fail(RuleMinLength, "must be at least %d long", r.MinLength)
+		}
+		if r.MaxLength > 0 && length > r.MaxLength {
+			fail(RuleMaxLength, "must be at most %d long", r.MaxLength)
+		}
+	}
+
+	if r.Pattern != "" {
+		s, ok := value.(string)
+		if !ok {
+			fail(RuleType, "must be %s", TypeString)
+		} else if !regexp.MustCompile(r.Pattern).MatchString(s) {
+			fail(RulePattern, "must match '%s'", r.Pattern)
+		}
+	}
+	return failed
+}
+
+// value returns the item field the rule checks, present is false for an empty field.
+// Numbers are returned as float64 like JSON numbers of the payload.
+func (r FieldRule) value(spec QueueItemSpec) (value interface{}, present bool) {
+	switch r.Field {
+	case "From":
+		return spec.From, spec.From != ""
+	case "To":
+		return spec.To, spec.To != ""
+	case "Amount":
+		return float64(spec.Amount), true
+	case "ExtraData":
+		return spec.ExtraData, len(spec.ExtraData) > 0
+	case "Payload":
+		return spec.Payload, spec.Payload != nil
+	}
+	value = spec.Payload
+	for _, name := range strings.Split(strings.TrimPrefix(r.Field, payloadFieldPrefix), ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+// jsonType returns JSON type of a payload value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return TypeString
+	case float64:
+		return TypeNumber
+	case bool:
+		return TypeBoolean
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	}
+	return ""
+}
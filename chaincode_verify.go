@@ -0,0 +1,340 @@
+package hlfq
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/s7techlab/cckit/router"
+)
+
+// Problems found by Verify
+const (
+	IssueHeadHasPrev    = "head item has prev link"
+	IssueTailHasNext    = "tail item has next link"
+	IssueTailMismatch   = "tail pointer does not point to the last linked item"
+	IssueBrokenLink     = "link points to a missing item"
+	IssueAsymmetricLink = "prev link does not point back to the previous item"
+	IssueCycle          = "link points to an already visited item"
+	IssueWrongList      = "link points to an item of another list"
+	IssueOrphan         = "item is not reachable from the list head"
+	IssueStatsMismatch  = "list stats do not match the list items"
+)
+
+// PendingListName is the list of issues found in the pending items and their stats
+const PendingListName = "pending"
+
+// IntegrityIssue is a broken invariant of an item list.
+// ItemID is the item the problem found at, empty for problems of the list pointers or stats.
+type IntegrityIssue struct {
+	List    string `json:"List"`
+	ItemID  string `json:"ItemID"`
+	Problem string `json:"Problem"`
+}
+
+// VerifyReport is the result of the queue integrity check.
+// Items after a broken link are not reachable from the head and reported as orphans.
+// Pending items are not linked yet, they are only counted.
+type VerifyReport struct {
+	QueueName    string           `json:"QueueName"`
+	OK           bool             `json:"OK"`
+	ItemCount    int              `json:"ItemCount"`
+	LinkedCount  int              `json:"LinkedCount"`
+	PendingCount int              `json:"PendingCount"`
+	OrphanIDs    []string         `json:"OrphanIDs"`
+	Issues       []IntegrityIssue `json:"Issues"`
+}
+
+// RepairReport lists items changed by Repair
+type RepairReport struct {
+	QueueName string `json:"QueueName"`
+	// RelinkedIDs are items which links were fixed
+	RelinkedIDs []string `json:"RelinkedIDs"`
+	// AppendedIDs are orphan items appended to the tails of their lists in ULID order
+	AppendedIDs []string `json:"AppendedIDs"`
+}
+
+// listCheck is a result of a list walk
+type listCheck struct {
+	list   itemList
+	linked []QueueItem // items reachable from the head by valid links
+	issues []IntegrityIssue
+}
+
+// queueVerify checks the linked lists of the queue: head has no prev, tail has no next,
+// links are symmetric, there are no cycles, every item is reachable from its list head
+// and list stats match the items
+// arg1 -> queueName string
+func queueVerify(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	items, err := readAllQueueItems(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := checkQueueLists(c, queueName, items)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := readPendingItems(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := VerifyReport{QueueName: queueName, ItemCount: len(items), PendingCount: len(pending),
+		OrphanIDs: []string{}, Issues: []IntegrityIssue{}}
+	linked := map[string]bool{}
+	for _, check := range checks {
+		report.Issues = append(report.Issues, check.issues...)
+		for _, item := range check.linked {
+			linked[item.ID.String()] = true
+		}
+		report.LinkedCount += len(check.linked)
+
+		stats, err := c.State().Get(ListStats{QueueName: queueName, Priority: check.list.Priority,
+			DeadLetter: check.list.DeadLetter}, &ListStats{}, ListStats{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read list stats")
+		}
+		count, amountSum := listTotals(items, check.list)
+		if stats.(ListStats).Count != count || stats.(ListStats).AmountSum != amountSum {
+			report.Issues = append(report.Issues, IntegrityIssue{List: check.list.name(), Problem: IssueStatsMismatch})
+		}
+	}
+	for _, item := range items {
+		if !linked[item.ID.String()] {
+			report.OrphanIDs = append(report.OrphanIDs, item.ID.String())
+			report.Issues = append(report.Issues,
+				IntegrityIssue{List: item.list().name(), ItemID: item.ID.String(), Problem: IssueOrphan})
+		}
+	}
+	match, err := pendingStatsMatch(c, queueName, pending)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		report.Issues = append(report.Issues, IntegrityIssue{List: PendingListName, Problem: IssueStatsMismatch})
+	}
+	report.OK = len(report.Issues) == 0
+	return report, nil
+}
+
+// pendingStatsMatch checks the pending stats of push txs count the pending items
+func pendingStatsMatch(c router.Context, queueName string, pending []QueueItem) (bool, error) {
+	res, err := c.State().List([]string{pendingStatsKeyPrefix, queueName}, &pendingStats{})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list pending stats")
+	}
+	counted := map[string]pendingStats{}
+	for _, s := range res.([]interface{}) {
+		counted[s.(pendingStats).TxItemID] = s.(pendingStats)
+	}
+	actual := map[string]pendingStats{}
+	for _, item := range pending {
+		s := actual[txItemID(item.ID)]
+		s.Count++
+		s.AmountSum += item.Amount
+		actual[txItemID(item.ID)] = s
+	}
+	if len(counted) != len(actual) {
+		return false, nil
+	}
+	for id, s := range actual {
+		if counted[id].Count != s.Count || counted[id].AmountSum != s.AmountSum {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// queueRepair rebuilds every list of the queue: items reachable from the head by valid links keep their order,
+// the rest of the list items are appended after them in ULID order followed by pending items.
+// Head and tail pointers and list stats are reset.
+// arg1 -> queueName string
+func queueRepair(c router.Context) (interface{}, error) {
+	queueName := c.ParamString(queueNameParam)
+	items, err := readAllQueueItems(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	// pending items are not reachable from the heads, they are appended after orphans
+	pending, err := readPendingItems(c, queueName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pending {
+		if err := deleteState(c, pendingItem(pending[i])); err != nil {
+			return nil, errors.Wrap(err, "failed to delete pending item")
+		}
+		countPending(c, pending[i], -1)
+		if err := insertState(c, pending[i]); err != nil {
+			return nil, errors.Wrap(err, "failed to save linked item")
+		}
+		addItemChange(c, ItemLinked, nil, &pending[i])
+	}
+	c.Set(pendingLinkedKey, queueName)
+	checks, err := checkQueueLists(c, queueName, items)
+	if err != nil {
+		return nil, err
+	}
+
+	report := RepairReport{QueueName: queueName, RelinkedIDs: []string{}, AppendedIDs: []string{}}
+	for _, check := range checks {
+		order := check.linked
+		linked := map[string]bool{}
+		for _, item := range check.linked {
+			linked[item.ID.String()] = true
+		}
+		for _, item := range append(items, pending...) { // items is sorted by ULID, pending by tx time
+			if item.list() == check.list && !linked[item.ID.String()] {
+				order = append(order, item)
+				report.AppendedIDs = append(report.AppendedIDs, item.ID.String())
+			}
+		}
+
+		relinked, err := relinkList(c, check.list, order)
+		if err != nil {
+			return nil, err
+		}
+		report.RelinkedIDs = append(report.RelinkedIDs, relinked...)
+	}
+	return report, nil
+}
+
+// relinkList links items in the order, saves changed items, list pointers and list stats,
+// returns IDs of changed items
+func relinkList(c router.Context, l itemList, order []QueueItem) (relinked []string, err error) {
+	headKey, tailKey := EmptyItemPointerKey, EmptyItemPointerKey
+	stats := ListStats{QueueName: l.QueueName, Priority: l.Priority, DeadLetter: l.DeadLetter}
+	for i, item := range order {
+		old := item
+		item.PrevKey, item.NextKey = EmptyItemPointerKey, EmptyItemPointerKey
+		if i > 0 {
+			item.PrevKey, _ = order[i-1].Key()
+		}
+		if i < len(order)-1 {
+			item.NextKey, _ = order[i+1].Key()
+		}
+		if !reflect.DeepEqual(old.PrevKey, item.PrevKey) || !reflect.DeepEqual(old.NextKey, item.NextKey) {
+			if err := putState(c, item); err != nil {
+				return nil, errors.Wrapf(err, "failed to relink item ID '%s'", item.ID.String())
+			}
+			addItemChange(c, ItemRelinked, &old, &item)
+			relinked = append(relinked, item.ID.String())
+		}
+		stats.Count++
+		stats.AmountSum += item.Amount
+	}
+	if len(order) > 0 {
+		headKey, _ = order[0].Key()
+		tailKey, _ = order[len(order)-1].Key()
+	}
+	if err := setHeadPointerTo(c, l, headKey); err != nil {
+		return nil, err
+	}
+	if err := setTailPointerTo(c, l, tailKey); err != nil {
+		return nil, err
+	}
+	if stats.Count == 0 {
+		err = deleteListStats(c, l)
+	} else {
+		err = putState(c, stats)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save list stats")
+	}
+	return relinked, nil
+}
+
+// checkQueueLists walks every list of the queue from the head, the walk of a list stops at the first broken link
+func checkQueueLists(c router.Context, queueName string, items []QueueItem) ([]listCheck, error) {
+	byID := map[string]QueueItem{}
+	for _, item := range items {
+		byID[item.ID.String()] = item
+	}
+	visited := map[string]bool{}
+	var checks []listCheck
+	for _, l := range append(queueLists(queueName), deadLetterList(queueName)) {
+		check, err := checkList(c, l, byID, visited)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func checkList(c router.Context, l itemList, byID map[string]QueueItem, visited map[string]bool) (check listCheck, err error) {
+	check.list = l
+	issue := func(itemID string, problem string) {
+		check.issues = append(check.issues, IntegrityIssue{List: l.name(), ItemID: itemID, Problem: problem})
+	}
+
+	key, err := readHeadItemKey(c, l)
+	if err != nil {
+		return check, err
+	}
+	prevKey, prevID := EmptyItemPointerKey, ""
+	for !isKeyEmpty(key) {
+		item, found := byID[keyItemID(key)]
+		itemKey, _ := item.Key()
+		switch {
+		case !found || !reflect.DeepEqual(key, itemKey):
+			issue(prevID, IssueBrokenLink)
+		case visited[item.ID.String()]:
+			issue(prevID, IssueCycle)
+		case item.list() != l:
+			issue(prevID, IssueWrongList)
+		case !reflect.DeepEqual(item.PrevKey, prevKey) && prevID == "":
+			issue(item.ID.String(), IssueHeadHasPrev)
+		case !reflect.DeepEqual(item.PrevKey, prevKey):
+			issue(item.ID.String(), IssueAsymmetricLink)
+		default:
+			visited[item.ID.String()] = true
+			check.linked = append(check.linked, item)
+			prevKey, prevID = key, item.ID.String()
+			key = item.NextKey
+			continue
+		}
+		break
+	}
+
+	tailKey, err := readTailItemKey(c, l)
+	if err != nil {
+		return check, err
+	}
+	if tail, found := byID[keyItemID(tailKey)]; found && !isKeyEmpty(tail.NextKey) {
+		issue(tail.ID.String(), IssueTailHasNext)
+	}
+	if !reflect.DeepEqual(tailKey, prevKey) {
+		issue(keyItemID(tailKey), IssueTailMismatch)
+	}
+	return check, nil
+}
+
+// readAllQueueItems reads all stored items of the queue sorted by ULID
+func readAllQueueItems(c router.Context, queueName string) ([]QueueItem, error) {
+	res, err := c.State().List([]string{queueItemKeyPrefix, queueName}, &QueueItem{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list queue items")
+	}
+	items := []QueueItem{}
+	for _, item := range res.([]interface{}) {
+		items = append(items, item.(QueueItem))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID.Compare(items[j].ID) < 0
+	})
+	return items, nil
+}
+
+// listTotals counts stored items of the list and their Amount
+func listTotals(items []QueueItem, l itemList) (count int, amountSum int) {
+	for _, item := range items {
+		if item.list() == l {
+			count++
+			amountSum += item.Amount
+		}
+	}
+	return count, amountSum
+}
@@ -3,6 +3,7 @@ package hlfq
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/oklog/ulid/v2"
@@ -10,32 +11,103 @@ import (
 
 const queuePointerTypeName = "queuePointer"
 
+const (
+	// MinPriority is the lowest (and the default) item priority
+	MinPriority = 0
+	// MaxPriority is the highest item priority, items with it are served first
+	MaxPriority = 9
+)
+
+// Queue is a registered named queue
+type Queue struct {
+	Name        string    `json:"Name"`
+	CreatedTime time.Time `json:"CreatedTime"`
+	// MaxDeliveryAttempts is a number of Reserve attempts after which a not acknowledged item
+	// moves to the dead-letter list, 0 means unlimited
+	MaxDeliveryAttempts int `json:"MaxDeliveryAttempts"`
+	// ArchivePopped keeps consumed items in the archive of the queue instead of deleting them
+	ArchivePopped bool `json:"ArchivePopped"`
+	// PrivateCollection is a private data collection keeping ExtraData of the queue items, optional
+	PrivateCollection string `json:"PrivateCollection"`
+	// PrivateReaders are MSP IDs allowed to read private ExtraData of the queue items
+	PrivateReaders []string `json:"PrivateReaders"`
+}
+
+// Key for Queue entry in chaincode state
+func (q Queue) Key() ([]string, error) {
+	return []string{queueKeyPrefix, q.Name}, nil
+}
+
+const (
+	headPointerName = "HeadPointer"
+	tailPointerName = "TailPointer"
+	// deadLetterListName replaces priority in keys of the dead-letter list pointers
+	deadLetterListName = "DeadLetter"
+)
+
+// itemList addresses a linked list of queue items, each priority band of a queue is a separate list,
+// dead-lettered items of the queue are kept in one more list
+type itemList struct {
+	QueueName  string
+	Priority   int
+	DeadLetter bool
+}
+
+// name identifies the list in state keys: the priority or deadLetterListName
+func (l itemList) name() string {
+	if l.DeadLetter {
+		return deadLetterListName
+	}
+	return strconv.Itoa(l.Priority)
+}
+
+func (l itemList) headPointer() *QueuePointer {
+	return &QueuePointer{QueueName: l.QueueName, Priority: l.Priority, DeadLetter: l.DeadLetter, PointerName: headPointerName}
+}
+
+func (l itemList) tailPointer() *QueuePointer {
+	return &QueuePointer{QueueName: l.QueueName, Priority: l.Priority, DeadLetter: l.DeadLetter, PointerName: tailPointerName}
+}
+
 // QueuePointer holds a key pointing to another state
 type QueuePointer struct {
+	QueueName   string
+	Priority    int
+	DeadLetter  bool
 	PointerName string
 	PointerKey  []string
 }
 
-// NewQueuePointer creates new QueuePointer (for Head or Tail)
-func NewQueuePointer(name string) *QueuePointer {
-	return &QueuePointer{PointerName: name}
+// NewQueuePointer creates new QueuePointer (for Head or Tail) of the named queue priority band
+func NewQueuePointer(queueName string, priority int, name string) *QueuePointer {
+	return &QueuePointer{QueueName: queueName, Priority: priority, PointerName: name}
 }
 
-// NewQueueHeadPointer creates a QueuePointer for HEAD
-func NewQueueHeadPointer() *QueuePointer {
-	return &QueuePointer{PointerName: "HeadPointer"}
+// NewQueueHeadPointer creates a QueuePointer for HEAD of the named queue priority band
+func NewQueueHeadPointer(queueName string, priority int) *QueuePointer {
+	return NewQueuePointer(queueName, priority, headPointerName)
 }
 
-// NewQueueTailPointer creates a QueuePointer for TAIL
-func NewQueueTailPointer() *QueuePointer {
-	return &QueuePointer{PointerName: "TailPointer"}
+// NewQueueTailPointer creates a QueuePointer for TAIL of the named queue priority band
+func NewQueueTailPointer(queueName string, priority int) *QueuePointer {
+	return NewQueuePointer(queueName, priority, tailPointerName)
 }
 
 // Key for QueuePointer entry in chaincode state
 func (qp QueuePointer) Key() ([]string, error) {
-	s := []string{queuePointerTypeName}
-	s = append(s, qp.PointerName)
-	return s, nil
+	list := itemList{QueueName: qp.QueueName, Priority: qp.Priority, DeadLetter: qp.DeadLetter}
+	return []string{queuePointerTypeName, qp.QueueName, qp.PointerName, list.name()}, nil
+}
+
+// Actor identifies a tx creator
+type Actor struct {
+	MSPID   string `json:"MSPID"`
+	Subject string `json:"Subject"`
+}
+
+// isEmpty shows the actor is not set
+func (a Actor) isEmpty() bool {
+	return a.MSPID == "" && a.Subject == ""
 }
 
 // QueueItemSpec chaincode method argument
@@ -44,30 +116,90 @@ type QueueItemSpec struct {
 	To        string `json:"To"`
 	Amount    int    `json:"Amount"`
 	ExtraData []byte `json:"ExtraData"`
+	// Payload is an arbitrary JSON object, optional
+	Payload map[string]interface{} `json:"Payload"`
+	// Priority from MinPriority to MaxPriority, optional
+	Priority int `json:"Priority"`
+	// NotBefore is the earliest time the item can be popped, optional
+	NotBefore time.Time `json:"NotBefore"`
 }
 
 // QueueItem struct for chaincode state
 type QueueItem struct {
 	// Queue sevice data
+	QueueName   string    `json:"QueueName"`
 	ID          ulid.ULID `json:"ID"`
 	PrevKey     []string  `json:"PrevKey"`
 	NextKey     []string  `json:"NextKey"`
 	CreatedTime time.Time `json:"CreatedTime"` // set by chaincode method
+	Creator     Actor     `json:"Creator"`     // tx creator of Push, empty for items stored before it was added
+	Priority    int       `json:"Priority"`    // item is linked into the list of its priority band
+	NotBefore   time.Time `json:"NotBefore"`   // item is not popped before, zero time means no delay
+	// Consumer lease, item stays in place but hidden from Pop and Reserve until the lease expires
+	LeaseOwner   Actor     `json:"LeaseOwner"`
+	LeaseExpires time.Time `json:"LeaseExpires"`
+	// DeliveryAttempts counts Reserve calls, DeadLettered is set when the item moved to the dead-letter list
+	DeliveryAttempts int  `json:"DeliveryAttempts"`
+	DeadLettered     bool `json:"DeadLettered"`
 	// Item Spec
 	From      string `json:"From"`
 	To        string `json:"To"`
 	Amount    int    `json:"Amount"`
 	ExtraData []byte `json:"ExtraData"`
+	// ExtraDataEncrypted shows ExtraData is encrypted with the key passed by the caller
+	ExtraDataEncrypted bool `json:"ExtraDataEncrypted"`
+	// ExtraData kept in the private collection is empty, the item keeps the collection and hex SHA-256 of the data
+	PrivateCollection string `json:"PrivateCollection"`
+	ExtraDataHash     string `json:"ExtraDataHash"`
+	// Payload is nil for items stored before it was added.
+	// JSON object keys are marshaled sorted, so every peer writes the same bytes.
+	Payload map[string]interface{} `json:"Payload"`
 }
 
 // Key for QueueItem entry in chaincode state
 func (qi QueueItem) Key() ([]string, error) {
-	return []string{queueItemKeyPrefix, qi.ID.String()}, nil
+	return []string{queueItemKeyPrefix, qi.QueueName, qi.ID.String()}, nil
 }
 
 func (qi QueueItem) String() string {
-	return fmt.Sprintf("QueueItem{ ID: %s, PrevKey: %v, NextKey: %v, From: %s, To: %s, Amount: %d, ExtraData: %v }",
-		qi.ID.String(), qi.PrevKey, qi.NextKey, qi.From, qi.To, qi.Amount, qi.ExtraData)
+	return fmt.Sprintf("QueueItem{ QueueName: %s, ID: %s, Priority: %d, PrevKey: %v, NextKey: %v, From: %s, To: %s, Amount: %d, ExtraData: %v, Payload: %v }",
+		qi.QueueName, qi.ID.String(), qi.Priority, qi.PrevKey, qi.NextKey, qi.From, qi.To, qi.Amount, qi.ExtraData, qi.Payload)
+}
+
+// spec returns the item fields set on Push
+func (qi QueueItem) spec() QueueItemSpec {
+	return QueueItemSpec{From: qi.From, To: qi.To, Amount: qi.Amount, ExtraData: qi.ExtraData, Payload: qi.Payload,
+		Priority: qi.Priority, NotBefore: qi.NotBefore}
+}
+
+// list returns the linked list the item belongs to
+func (qi QueueItem) list() itemList {
+	if qi.DeadLettered {
+		return itemList{QueueName: qi.QueueName, DeadLetter: true}
+	}
+	return itemList{QueueName: qi.QueueName, Priority: qi.Priority}
+}
+
+// isReady shows the item is allowed to be popped at the time t (tx time)
+func (qi QueueItem) isReady(t time.Time) bool {
+	return !qi.NotBefore.After(t)
+}
+
+// isLeased shows the item is reserved by a consumer at the time t (tx time)
+func (qi QueueItem) isLeased(t time.Time) bool {
+	return !qi.LeaseOwner.isEmpty() && qi.LeaseExpires.After(t)
+}
+
+// isAvailable shows the item can be popped or reserved at the time t (tx time)
+func (qi QueueItem) isAvailable(t time.Time) bool {
+	return qi.isReady(t) && !qi.isLeased(t)
+}
+
+// isExhausted shows the lease expired at the time t and the item used all delivery attempts,
+// so it should be dead-lettered. maxAttempts = 0 means unlimited attempts
+func (qi QueueItem) isExhausted(t time.Time, maxAttempts int) bool {
+	return maxAttempts > 0 && qi.DeliveryAttempts >= maxAttempts &&
+		!qi.LeaseOwner.isEmpty() && !qi.isLeased(t)
 }
 
 func (qi QueueItem) hasNext() bool {